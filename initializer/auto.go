@@ -0,0 +1,131 @@
+package initializer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// magics are the magic bytes of the formats the auto processor detects, by
+// the processor handling them.
+var magics = []struct {
+	processor string
+	magic     []byte
+}{
+	{"unzip", []byte("PK\x03\x04")},
+	{"unzip", []byte("PK\x05\x06")}, // empty zip file
+	{"gunzip", gzipMagic},
+	{"unxz", []byte("\xfd7zXZ\x00")},
+	{"unzstd", []byte("\x28\xb5\x2f\xfd")},
+	{"bunzip2", []byte("BZh")},
+}
+
+// extensions are the filename extensions of the formats the auto processor
+// detects. They only decide if tar files, which have no magic bytes before
+// ustar, are processed, the magic bytes win otherwise.
+var extensions = []struct {
+	ext        string
+	processors []string
+}{
+	{".zip", []string{"unzip"}},
+	{".tar", []string{"untar"}},
+	{".tgz", []string{"gunzip", "untar"}},
+	{".tbz2", []string{"bunzip2", "untar"}},
+	{".tbz", []string{"bunzip2", "untar"}},
+	{".txz", []string{"unxz", "untar"}},
+	{".tzst", []string{"unzstd", "untar"}},
+	{".gz", []string{"gunzip"}},
+	{".bz2", []string{"bunzip2"}},
+	{".xz", []string{"unxz"}},
+	{".zst", []string{"unzstd"}},
+}
+
+// sniffLen is the number of bytes needed to detect all formats, including
+// the ustar magic of tar files at offset 257.
+const sniffLen = 512
+
+func isTar(header []byte) bool {
+	return len(header) >= 262 && bytes.Equal(header[257:262], []byte("ustar"))
+}
+
+// sniff returns the processor for the format of header.
+func sniff(header []byte) string {
+	for _, m := range magics {
+		if bytes.HasPrefix(header, m.magic) {
+			return m.processor
+		}
+	}
+	if isTar(header) {
+		return "untar"
+	}
+	return ""
+}
+
+// processorsForName returns the processors for the extensions of name, like
+// gunzip and untar for foo.tar.gz.
+func processorsForName(name string) []string {
+	name = strings.ToLower(name)
+	for _, e := range extensions {
+		if strings.HasSuffix(name, e.ext) {
+			processors := e.processors
+			if len(processors) == 1 && decompressors[processors[0]] != nil {
+				processors = append(processors, processorsForName(strings.TrimSuffix(name, e.ext))...)
+			}
+			return processors
+		}
+	}
+	return nil
+}
+
+// detectProcessors returns the processors extracting the file at path from
+// its magic bytes. Compressed files are decompressed to look for a tar file
+// inside. Only tar files without ustar magic are detected by the extensions
+// of name, like foo.tar or foo.tar.gz. Directories and unknown formats need
+// no processors.
+func detectProcessors(path, name string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open file %s: %w", path, err)
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err != nil || fi.IsDir() {
+		return nil, err
+	}
+	header := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, fmt.Errorf("couldn't read file %s: %w", path, err)
+	}
+	byName := processorsForName(name)
+	namedTar := len(byName) > 0 && byName[len(byName)-1] == "untar"
+	processor := sniff(header[:n])
+	if processor == "" {
+		if namedTar && len(byName) == 1 {
+			return byName, nil
+		}
+		return nil, nil
+	}
+	newReader, ok := decompressors[processor]
+	if !ok {
+		return []string{processor}, nil
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("couldn't read file %s: %w", path, err)
+	}
+	r, err := newReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open compressed file %s: %w", path, err)
+	}
+	defer r.Close()
+	n, err = io.ReadFull(r, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, fmt.Errorf("couldn't decompress file %s: %w", path, err)
+	}
+	if isTar(header[:n]) || namedTar {
+		return []string{processor, "untar"}, nil
+	}
+	return []string{processor}, nil
+}
@@ -0,0 +1,211 @@
+package initializer
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/google/go-cmp/cmp"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// bzip2 compressed "hello world\n", the standard library has no compressor.
+const bzip2Hello = "425a68393141592653594eece83600000251800010400006449080200031064c4101a7a9a580bb9431f8bb9229c28482776741b0"
+
+func tarball(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(content))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func compress(t *testing.T, processor string, data []byte) []byte {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	var err error
+	switch processor {
+	case "gunzip":
+		w = gzip.NewWriter(&buf)
+	case "unxz":
+		w, err = xz.NewWriter(&buf)
+	case "unzstd":
+		w, err = zstd.NewWriter(&buf)
+	case "unzip":
+		zw := zip.NewWriter(&buf)
+		f, _ := zw.Create("hello")
+		f.Write(data)
+		zw.Close()
+		return buf.Bytes()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDetectProcessors(t *testing.T) {
+	hello := []byte("hello world\n")
+	tarHello := tarball(t, map[string]string{"hello": "hello world\n"})
+	bz2, _ := hex.DecodeString(bzip2Hello)
+	for _, tc := range []struct {
+		name     string
+		filename string
+		content  []byte
+		expected []string
+	}{
+		{name: "plain", filename: "hello", content: hello},
+		{name: "zip", filename: "download", content: compress(t, "unzip", hello), expected: []string{"unzip"}},
+		{name: "tar", filename: "download", content: tarHello, expected: []string{"untar"}},
+		{name: "tar.gz", filename: "download", content: compress(t, "gunzip", tarHello), expected: []string{"gunzip", "untar"}},
+		{name: "gz", filename: "download", content: compress(t, "gunzip", hello), expected: []string{"gunzip"}},
+		{name: "xz", filename: "download", content: compress(t, "unxz", hello), expected: []string{"unxz"}},
+		{name: "tar.zst", filename: "download", content: compress(t, "unzstd", tarHello), expected: []string{"unzstd", "untar"}},
+		{name: "bz2", filename: "download", content: bz2, expected: []string{"bunzip2"}},
+		{name: "unknown by name", filename: "model.tar", content: hello, expected: []string{"untar"}},
+		{name: "tar by name", filename: "model.tar.zst", content: compress(t, "unzstd", hello), expected: []string{"unzstd", "untar"}},
+		{name: "tgz by name without magic", filename: "model.TGZ", content: hello},
+		{name: "tgz by name", filename: "model.TGZ", content: compress(t, "gunzip", hello), expected: []string{"gunzip", "untar"}},
+		{name: "name contradicting magic", filename: "model.zip", content: compress(t, "unxz", hello), expected: []string{"unxz"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "file")
+			if err := os.WriteFile(path, tc.content, 0644); err != nil {
+				t.Fatal(err)
+			}
+			processors, err := detectProcessors(path, tc.filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.expected, processors); diff != "" {
+				t.Fatalf("unexpected processors (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAutoProcessor(t *testing.T) {
+	tarGz := compress(t, "gunzip", tarball(t, map[string]string{"dir/hello": "hello world\n"}))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/download":
+			w.Header().Set("Content-Disposition", `attachment; filename="model.tar.gz"`)
+			w.Write(tarGz)
+		case "/model.zst":
+			w.Write(compress(t, "unzstd", []byte("weights")))
+		case "/named":
+			w.Header().Set("Content-Disposition", `attachment; filename="model.tar"`)
+			w.Write([]byte("broken"))
+		default:
+			w.Write([]byte("plain"))
+		}
+	}))
+	defer server.Close()
+
+	sum := sha256.Sum256([]byte("plain"))
+	plainSHA256 := hex.EncodeToString(sum[:])
+	root := t.TempDir()
+	init, err := NewInitializer(log.NewNopLogger(), map[string]string{
+		"model":            "http+auto" + server.URL[len("http"):] + "/download",
+		"weights":          "http+auto" + server.URL[len("http"):] + "/model.zst",
+		"plain":            "http+auto" + server.URL[len("http"):] + "/plain.txt",
+		"ext/model.tar.gz": "http+auto" + server.URL[len("http"):] + "/download",
+		"ext/weights.zst":  "http+auto" + server.URL[len("http"):] + "/model.zst",
+		// The filename of the failed mirror doesn't apply to the next one.
+		"mirrored": "http+auto" + server.URL[len("http"):] + "/named http+auto" + server.URL[len("http"):] + "/plain.txt#sha256=" + plainSHA256,
+	}, nil, nil, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := init.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(map[string]string{
+		"model/dir/hello":            "hello world\n",
+		"weights":                    "weights",
+		"plain":                      "plain",
+		"ext/model.tar.gz/dir/hello": "hello world\n",
+		"ext/weights.zst":            "weights",
+		"mirrored":                   "plain",
+	}, readTree(t, root)); diff != "" {
+		t.Fatalf("unexpected files (-want +got):\n%s", diff)
+	}
+}
+
+func TestDecompressProcessors(t *testing.T) {
+	bz2, _ := hex.DecodeString(bzip2Hello)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/hello.gz":
+			w.Write(compress(t, "gunzip", []byte("hello world\n")))
+		case "/hello.zst":
+			w.Write(compress(t, "unzstd", []byte("hello world\n")))
+		case "/hello.xz":
+			w.Write(compress(t, "unxz", []byte("hello world\n")))
+		case "/hello.bz2":
+			w.Write(bz2)
+		}
+	}))
+	defer server.Close()
+	host := server.URL[len("http"):]
+
+	root := t.TempDir()
+	init, err := NewInitializer(log.NewNopLogger(), map[string]string{
+		"gz/model.pt.gz": "http+gunzip" + host + "/hello.gz",
+		"zst":            "http+unzstd" + host + "/hello.zst",
+		"xz/data.XZ":     "http+unxz" + host + "/hello.xz",
+		"bz2":            "http+bunzip2" + host + "/hello.bz2",
+	}, nil, nil, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := init.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(map[string]string{
+		"gz/model.pt": "hello world\n",
+		"zst":         "hello world\n",
+		"xz/data":     "hello world\n",
+		"bz2":         "hello world\n",
+	}, readTree(t, root)); diff != "" {
+		t.Fatalf("unexpected files (-want +got):\n%s", diff)
+	}
+
+	if err := (&Sources{
+		"model.pt.gz": "http+gunzip" + host + "/hello.gz",
+		"model.pt":    "http" + host + "/hello.gz",
+	}).Validate(); err == nil || !strings.Contains(err.Error(), "decompressing it overwrites path model.pt") {
+		t.Fatalf("expected error for colliding paths, got %v", err)
+	}
+
+	init, err = NewInitializer(log.NewNopLogger(), map[string]string{"broken": "http+unzstd" + host + "/hello.gz"}, nil, nil, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := init.Init(); err == nil {
+		t.Fatal("expected error for wrong format")
+	}
+}
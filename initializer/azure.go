@@ -0,0 +1,176 @@
+package initializer
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const azureAPIVersion = "2021-08-06"
+
+// azureDownloader downloads blobs from Azure Blob Storage. Sources have the
+// form az://account/container/blob and are downloaded recursively if the blob
+// is empty or ends with a slash. The fragment configures the SAS token and the
+// endpoint.
+type azureDownloader struct {
+	HTTPClient *http.Client
+}
+
+type azureSource struct {
+	container string
+	blob      string
+	endpoint  string
+	// sas is kept as given so it shows up verbatim in urls and can be
+	// redacted.
+	sas string
+}
+
+func parseAzureSource(source string) (*azureSource, error) {
+	u, err := url.Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("account is missing")
+	}
+	parts := strings.SplitN(strings.TrimPrefix(u.Path, "/"), "/", 2)
+	if parts[0] == "" {
+		return nil, fmt.Errorf("container is missing")
+	}
+	src := &azureSource{
+		container: parts[0],
+		endpoint:  "https://" + u.Host + ".blob.core.windows.net",
+	}
+	if len(parts) == 2 {
+		src.blob = parts[1]
+	}
+
+	values, err := url.ParseQuery(u.EscapedFragment())
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range values {
+		if len(v) != 1 {
+			return nil, fmt.Errorf("invalid fragment %s: only one value is supported", k)
+		}
+		switch k {
+		case "endpoint":
+			src.endpoint = strings.TrimSuffix(v[0], "/")
+		case "sas":
+			src.sas = strings.TrimPrefix(v[0], "?")
+			if _, err := url.ParseQuery(src.sas); err != nil {
+				return nil, fmt.Errorf("invalid sas token: %w", err)
+			}
+		default:
+			return nil, fmt.Errorf("invalid fragment %s: only endpoint and sas are supported", k)
+		}
+	}
+	return src, nil
+}
+
+// url returns the url for path below the account with the SAS token and
+// query appended.
+func (s *azureSource) url(path string, query url.Values) string {
+	params := []string{}
+	if s.sas != "" {
+		params = append(params, s.sas)
+	}
+	if len(query) > 0 {
+		params = append(params, query.Encode())
+	}
+	u := s.endpoint + "/" + (&url.URL{Path: path}).EscapedPath()
+	if len(params) > 0 {
+		u += "?" + strings.Join(params, "&")
+	}
+	return u
+}
+
+func (d *azureDownloader) Download(path, source string) error {
+	src, err := parseAzureSource(source)
+	if err != nil {
+		return err
+	}
+	if src.blob != "" && !strings.HasSuffix(src.blob, "/") {
+		return d.downloadBlob(path, src, src.blob)
+	}
+
+	blobs, err := d.list(src)
+	if err != nil {
+		return err
+	}
+	return downloadPrefix(path, src.blob, blobs, func(path, blob string) error {
+		return d.downloadBlob(path, src, blob)
+	})
+}
+
+func (d *azureDownloader) downloadBlob(path string, src *azureSource, blob string) error {
+	resp, err := d.get(src.url(src.container+"/"+blob, nil))
+	if err != nil {
+		return err
+	}
+	if err := saveResponse(path, resp); err != nil {
+		return fmt.Errorf("couldn't download blob %s/%s: %w", src.container, blob, err)
+	}
+	return nil
+}
+
+type azureBlobList struct {
+	Blobs struct {
+		Blob []struct {
+			Name string
+		}
+	}
+	NextMarker string
+}
+
+// list returns all blobs below the source prefix.
+func (d *azureDownloader) list(src *azureSource) ([]string, error) {
+	var (
+		blobs  []string
+		marker string
+	)
+	for {
+		query := url.Values{"restype": {"container"}, "comp": {"list"}, "prefix": {src.blob}}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+		resp, err := d.get(src.url(src.container, query))
+		if err != nil {
+			return nil, err
+		}
+		var list azureBlobList
+		if err := decodeXMLResponse(resp, &list); err != nil {
+			return nil, fmt.Errorf("couldn't list blobs in %s/%s: %w", src.container, src.blob, err)
+		}
+		for _, blob := range list.Blobs.Blob {
+			blobs = append(blobs, blob.Name)
+		}
+		if list.NextMarker == "" {
+			return blobs, nil
+		}
+		marker = list.NextMarker
+	}
+}
+
+func (d *azureDownloader) get(u string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, stripQuery(err)
+	}
+	req.Header.Set("x-ms-version", azureAPIVersion)
+	resp, err := d.HTTPClient.Do(req)
+	if err != nil {
+		return nil, stripQuery(err)
+	}
+	return resp, nil
+}
+
+// stripQuery removes the query, which includes the SAS token, from the url
+// of url errors so it doesn't end up in errors and logs.
+func stripQuery(err error) error {
+	if uerr, ok := err.(*url.Error); ok {
+		uerr.URL = strings.SplitN(uerr.URL, "?", 2)[0]
+	}
+	return err
+}
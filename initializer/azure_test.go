@@ -0,0 +1,132 @@
+package initializer
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeAzurite serves blobs from an account using path style urls like
+// Azurite and requires the SAS signature sig.
+func fakeAzurite(t *testing.T, account, container, sig string, blobs map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("sig") != sig || r.Header.Get("x-ms-version") == "" {
+			http.Error(w, "AuthenticationFailed", http.StatusForbidden)
+			return
+		}
+		prefix := "/" + account + "/" + container
+		if r.URL.Path == prefix && r.URL.Query().Get("comp") == "list" {
+			fmt.Fprint(w, "<EnumerationResults><Blobs>")
+			for name := range blobs {
+				if strings.HasPrefix(name, r.URL.Query().Get("prefix")) {
+					fmt.Fprintf(w, "<Blob><Name>%s</Name></Blob>", name)
+				}
+			}
+			fmt.Fprint(w, "</Blobs><NextMarker/></EnumerationResults>")
+			return
+		}
+		content, ok := blobs[strings.TrimPrefix(r.URL.Path, prefix+"/")]
+		if !ok {
+			http.Error(w, "BlobNotFound", http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, content)
+	}))
+}
+
+func TestAzureDownloader(t *testing.T) {
+	server := fakeAzurite(t, "devstoreaccount1", "models", "s3cr/t+", map[string]string{
+		"agent/weights.bin":      "weights",
+		"agent/config/agent.yml": "config",
+		"other/file":             "other",
+	})
+	defer server.Close()
+
+	sas := "sv=2021-08-06&sp=rl&sig=" + url.QueryEscape("s3cr/t+")
+	fragment := "#endpoint=" + url.QueryEscape(server.URL+"/devstoreaccount1") + "&sas=" + url.QueryEscape(sas)
+	for _, tc := range []struct {
+		name        string
+		source      string
+		expected    map[string]string
+		expectedErr string
+	}{
+		{
+			name:     "blob",
+			source:   "az://devstoreaccount1/models/agent/weights.bin" + fragment,
+			expected: map[string]string{".": "weights"},
+		},
+		{
+			name:   "prefix",
+			source: "az://devstoreaccount1/models/agent/" + fragment,
+			expected: map[string]string{
+				"weights.bin":      "weights",
+				"config/agent.yml": "config",
+			},
+		},
+		{
+			name:        "missing sas",
+			source:      "az://devstoreaccount1/models/agent/weights.bin#endpoint=" + url.QueryEscape(server.URL+"/devstoreaccount1"),
+			expectedErr: "couldn't download blob models/agent/weights.bin: unexpected status code 403: AuthenticationFailed\n",
+		},
+		{
+			name:        "missing container",
+			source:      "az://devstoreaccount1/",
+			expectedErr: "container is missing",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "dest")
+			err := (&azureDownloader{HTTPClient: server.Client()}).Download(path, tc.source)
+			if tc.expectedErr != "" {
+				if err == nil || err.Error() != tc.expectedErr {
+					t.Fatalf("expected error %q, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, expected := range tc.expected {
+				content, err := os.ReadFile(filepath.Join(path, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(content) != expected {
+					t.Errorf("unexpected content in %s: %q", name, content)
+				}
+			}
+		})
+	}
+}
+
+func TestAzureDownloaderErrorHidesSAS(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	endpoint := server.URL + "/devstoreaccount1"
+	server.Close()
+
+	sas := "sv=2021-08-06&sp=rl&sig=" + url.QueryEscape("s3cr/t+")
+	source := "az://devstoreaccount1/models/weights.bin#endpoint=" + url.QueryEscape(endpoint) + "&sas=" + url.QueryEscape(sas)
+	err := (&azureDownloader{HTTPClient: http.DefaultClient}).Download(filepath.Join(t.TempDir(), "dest"), source)
+	if err == nil {
+		t.Fatal("expected error for closed server")
+	}
+	if strings.Contains(err.Error(), "sig") || strings.Contains(err.Error(), "s3cr") {
+		t.Fatalf("error leaks sas token: %v", err)
+	}
+}
+
+func TestAzureSourceKeepsSAS(t *testing.T) {
+	sas := "sv=2021-08-06&sp=rl&sig=" + url.QueryEscape("s3cr/t+")
+	src, err := parseAzureSource("az://account/models#sas=" + url.QueryEscape("?"+sas))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u := src.url("models", url.Values{"comp": {"list"}}); u != "https://account.blob.core.windows.net/models?"+sas+"&comp=list" {
+		t.Fatalf("unexpected url %s", u)
+	}
+}
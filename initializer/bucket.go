@@ -0,0 +1,25 @@
+package initializer
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// downloadPrefix downloads all keys below prefix to path, keeping their
+// relative paths. Directory markers are skipped.
+func downloadPrefix(path, prefix string, keys []string, download func(path, key string) error) error {
+	for _, key := range keys {
+		rel := strings.TrimPrefix(key, prefix)
+		if rel == "" || strings.HasSuffix(rel, "/") {
+			continue
+		}
+		if !strings.HasPrefix(key, prefix) || !filepath.IsLocal(rel) {
+			return fmt.Errorf("invalid key %s: not below prefix %s", key, prefix)
+		}
+		if err := download(filepath.Join(path, filepath.FromSlash(rel)), key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
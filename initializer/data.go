@@ -0,0 +1,58 @@
+package initializer
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// literalPrefix marks sources which are the file content itself. Everything
+// after it is written as is, so it may span multiple lines.
+const literalPrefix = "literal:"
+
+// dataDownloader writes inline content to files. Sources are either RFC 2397
+// data urls like data:text/plain;base64,SGVsbG8= or data:,Hello%2C%20World or
+// literal content like literal:Hello, World.
+type dataDownloader struct{}
+
+func (d *dataDownloader) Download(dest, source string) error {
+	content, err := parseDataSource(source)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", filepath.Dir(dest), err)
+	}
+	if err := os.WriteFile(dest, content, 0644); err != nil {
+		return fmt.Errorf("failed to write file %s: %w", dest, err)
+	}
+	return nil
+}
+
+func parseDataSource(source string) ([]byte, error) {
+	if strings.HasPrefix(source, literalPrefix) {
+		return []byte(strings.TrimPrefix(source, literalPrefix)), nil
+	}
+	if !strings.HasPrefix(source, "data:") {
+		return nil, fmt.Errorf("invalid data url: needs to start with data:")
+	}
+	mediaType, data, ok := strings.Cut(strings.TrimPrefix(source, "data:"), ",")
+	if !ok {
+		return nil, fmt.Errorf("invalid data url: missing comma before data")
+	}
+	content, err := url.PathUnescape(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data url: %w", err)
+	}
+	if !strings.HasSuffix(mediaType, ";base64") {
+		return []byte(content), nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(content), ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid data url: couldn't decode base64 data: %w", err)
+	}
+	return decoded, nil
+}
@@ -0,0 +1,106 @@
+package initializer
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseDataSource(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		source      string
+		expected    string
+		expectedErr string
+	}{
+		{
+			name:     "percent encoded",
+			source:   "data:,Hello%2C%20World%0A",
+			expected: "Hello, World\n",
+		},
+		{
+			name:     "base64",
+			source:   "data:text/plain;charset=utf-8;base64,SGVsbG8sIFdvcmxkCg==",
+			expected: "Hello, World\n",
+		},
+		{
+			name:     "percent encoded base64",
+			source:   "data:;base64,SGVsbG8sIFdvcmxkCg%3D%3D",
+			expected: "Hello, World\n",
+		},
+		{
+			name:     "literal",
+			source:   "literal:foo: bar\nbaz: {\"a\": 1}\n",
+			expected: "foo: bar\nbaz: {\"a\": 1}\n",
+		},
+		{
+			name:        "missing comma",
+			source:      "data:text/plain;base64",
+			expectedErr: "invalid data url: missing comma before data",
+		},
+		{
+			name:        "invalid base64",
+			source:      "data:;base64,!!!",
+			expectedErr: "invalid data url: couldn't decode base64 data: illegal base64 data at input byte 0",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			content, err := parseDataSource(tc.source)
+			if tc.expectedErr != "" {
+				if err == nil || err.Error() != tc.expectedErr {
+					t.Fatalf("expected error %q, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.expected, string(content)); diff != "" {
+				t.Errorf("content mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestInitializerInlineSources(t *testing.T) {
+	var (
+		sw   = &sliceWriter{}
+		root = t.TempDir()
+	)
+	init, err := NewInitializer(log.NewLogfmtLogger(sw), map[string]string{
+		"env":             "literal:TOKEN={{ .Secrets.token }}\nDEBUG=1\n",
+		"settings.yaml":   "data:,difficulty%3A%203",
+		"agent/config.js": "data:application/json;base64,eyJhIjogMX0=",
+		"note":            "literal:see https://example.com",
+		"value":           "literal:value\n",
+	}, map[string]string{"token": "abcd"}, nil, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := init.Init(); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"env":             "TOKEN=abcd\nDEBUG=1\n",
+		"settings.yaml":   "difficulty: 3",
+		"agent/config.js": `{"a": 1}`,
+		"note":            "see https://example.com",
+		"value":           "value\n",
+	}
+	if diff := cmp.Diff(expected, readTree(t, root)); diff != "" {
+		t.Errorf("files mismatch (-want +got):\n%s", diff)
+	}
+	expectedLog := []string{
+		"level=info msg=writing path=agent/config.js source=data:",
+		"level=info msg=writing path=env source=literal:",
+		"level=info msg=writing path=note source=literal:",
+		"level=info msg=writing path=settings.yaml source=data:",
+		"level=info msg=writing path=value source=literal:",
+	}
+	sort.Strings(sw.slices)
+	if diff := cmp.Diff(expectedLog, sw.slices); diff != "" {
+		t.Errorf("log mismatch (-want +got):\n%s", diff)
+	}
+}
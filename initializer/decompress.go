@@ -0,0 +1,137 @@
+package initializer
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// decompressors return a decompressing reader for each decompression
+// processor.
+var decompressors = map[string]func(io.Reader) (io.ReadCloser, error){
+	"gunzip": func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	},
+	"bunzip2": func(r io.Reader) (io.ReadCloser, error) {
+		return io.NopCloser(bzip2.NewReader(r)), nil
+	},
+	"unxz": func(r io.Reader) (io.ReadCloser, error) {
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(xr), nil
+	},
+	"unzstd": func(r io.Reader) (io.ReadCloser, error) {
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	},
+}
+
+// decompressedExtensions are the extensions removed from the path of
+// decompressed files.
+var decompressedExtensions = map[string]string{
+	"gunzip":  ".gz",
+	"bunzip2": ".bz2",
+	"unxz":    ".xz",
+	"unzstd":  ".zst",
+}
+
+// decompressFile replaces the file at path with its decompressed content and
+// returns its new path. If path has the extension of the format, like
+// model.pt.gz, it is written to the path without it, like model.pt, and
+// path is removed. The content is streamed to a temporary file next to
+// path, so nothing is held in memory and path is only replaced on success.
+func decompressFile(path, processor string) (string, error) {
+	dest := decompressedPath(path, processor)
+	if err := decompressTo(path, dest, processor); err != nil {
+		return "", err
+	}
+	if dest != path {
+		if err := os.Remove(path); err != nil {
+			return "", fmt.Errorf("couldn't remove compressed file %s: %w", path, err)
+		}
+	}
+	return dest, nil
+}
+
+// decompressedPath returns path without the extension of the format of
+// processor, or path if it doesn't have it.
+func decompressedPath(path, processor string) string {
+	if ext := decompressedExtensions[processor]; strings.HasSuffix(strings.ToLower(path), ext) && len(filepath.Base(path)) > len(ext) {
+		return path[:len(path)-len(ext)]
+	}
+	return path
+}
+
+func decompressTo(path, dest, processor string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("couldn't open file %s: %w", path, err)
+	}
+	defer in.Close()
+	r, err := decompressors[processor](in)
+	if err != nil {
+		return fmt.Errorf("couldn't open compressed file %s: %w", path, err)
+	}
+	defer r.Close()
+
+	out, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return fmt.Errorf("couldn't create file for %s: %w", path, err)
+	}
+	defer os.Remove(out.Name())
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return fmt.Errorf("couldn't decompress file %s: %w", path, err)
+	}
+	if err := out.Chmod(0644); err != nil {
+		out.Close()
+		return fmt.Errorf("couldn't decompress file %s: %w", path, err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("couldn't decompress file %s: %w", path, err)
+	}
+	if err := os.Rename(out.Name(), dest); err != nil {
+		return fmt.Errorf("couldn't write file %s: %w", dest, err)
+	}
+	return nil
+}
+
+// untarFile replaces the tar file at path with a directory holding its
+// content.
+func untarFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("couldn't open tar file %s: %w", path, err)
+	}
+	defer f.Close()
+	dir, err := os.MkdirTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return fmt.Errorf("couldn't create directory for %s: %w", path, err)
+	}
+	defer os.RemoveAll(dir)
+	if err := extractTar(f, dir); err != nil {
+		return fmt.Errorf("couldn't extract tar file %s: %w", path, err)
+	}
+	if err := os.Chmod(dir, 0755); err != nil {
+		return fmt.Errorf("couldn't create directory %s: %w", path, err)
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("couldn't remove tar file %s: %w", path, err)
+	}
+	if err := os.Rename(dir, path); err != nil {
+		return fmt.Errorf("couldn't create directory %s: %w", path, err)
+	}
+	return nil
+}
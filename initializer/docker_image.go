@@ -0,0 +1,211 @@
+package initializer
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+// dockerImageDownloader copies a directory out of a container image without
+// running it. Sources have the form
+// docker-image://[user:password@]registry/repository:tag#path=/app. The
+// layers of the image are applied in order, honoring whiteouts, and the
+// selected path is moved to the source path.
+type dockerImageDownloader struct {
+	HTTPClient *http.Client
+}
+
+func (d *dockerImageDownloader) Download(dest, source string) error {
+	ref, err := parseOCIReference(source)
+	if err != nil {
+		return err
+	}
+	var (
+		prefix   = ""
+		platform = "linux/amd64"
+	)
+	for k, v := range ref.fragment {
+		if len(v) != 1 {
+			return fmt.Errorf("invalid fragment %s: only one value is supported", k)
+		}
+		switch k {
+		case "path":
+			prefix = strings.Trim(path.Clean("/"+v[0]), "/")
+		case "platform":
+			platform = v[0]
+		default:
+			return fmt.Errorf("invalid fragment %s: only path, platform and plain_http are supported", k)
+		}
+	}
+
+	client := &registryClient{HTTPClient: d.HTTPClient, ref: ref}
+	manifest, err := d.platformManifest(client, platform)
+	if err != nil {
+		return err
+	}
+
+	// Apply the layers next to dest so the result can be renamed into place.
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("couldn't create directory %s: %w", filepath.Dir(dest), err)
+	}
+	root, err := os.MkdirTemp(filepath.Dir(dest), ".image")
+	if err != nil {
+		return fmt.Errorf("couldn't create directory for image: %w", err)
+	}
+	defer os.RemoveAll(root)
+
+	for _, layer := range manifest.Layers {
+		err := client.blob(layer, func(r io.Reader) error {
+			return applyLayer(r, root, prefix)
+		})
+		if err != nil {
+			return fmt.Errorf("couldn't apply layer %s: %w", layer.Digest, err)
+		}
+	}
+
+	selected := filepath.Join(root, filepath.FromSlash(prefix))
+	if _, err := os.Lstat(selected); err != nil {
+		return fmt.Errorf("path /%s not found in image", prefix)
+	}
+	if err := os.Rename(selected, dest); err != nil {
+		return fmt.Errorf("couldn't move /%s to %s: %w", prefix, dest, err)
+	}
+	return nil
+}
+
+// platformManifest returns the image manifest, resolving an index to the
+// manifest for platform (os/architecture[/variant]).
+func (d *dockerImageDownloader) platformManifest(client *registryClient, platform string) (*ociManifest, error) {
+	manifest, err := client.manifest(client.ref.reference)
+	if err != nil {
+		return nil, err
+	}
+	if len(manifest.Manifests) == 0 {
+		return manifest, nil
+	}
+	for _, m := range manifest.Manifests {
+		if m.Platform == nil {
+			continue
+		}
+		p := m.Platform.OS + "/" + m.Platform.Architecture
+		if m.Platform.Variant != "" && strings.Count(platform, "/") == 2 {
+			p += "/" + m.Platform.Variant
+		}
+		if p == platform {
+			return client.manifest(m.Digest)
+		}
+	}
+	return nil, fmt.Errorf("no manifest for platform %s in %s", platform, client.ref.reference)
+}
+
+// applyLayer applies a layer tar stream to root, limited to entries below
+// prefix. Whiteout files remove entries of previous layers.
+func applyLayer(r io.Reader, root, prefix string) error {
+	return walkTar(r, func(tr *tar.Reader, hdr *tar.Header) error {
+		name := strings.Trim(path.Clean("/"+hdr.Name), "/")
+		if name == "" {
+			return nil
+		}
+		dir, base := path.Split(name)
+		dir = strings.TrimSuffix(dir, "/")
+
+		switch {
+		case base == whiteoutOpaque:
+			if !overlapsPrefix(dir, prefix) {
+				return nil
+			}
+			return clearDir(filepath.Join(root, filepath.FromSlash(dir)))
+		case strings.HasPrefix(base, whiteoutPrefix):
+			target := path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))
+			if !overlapsPrefix(target, prefix) {
+				return nil
+			}
+			if err := checkNoSymlinkParents(root, filepath.FromSlash(target)); err != nil {
+				return err
+			}
+			return os.RemoveAll(filepath.Join(root, filepath.FromSlash(target)))
+		}
+
+		if !withinPrefix(name, prefix) {
+			return nil
+		}
+		switch hdr.Typeflag {
+		case tar.TypeSymlink:
+			// Only the prefix ends up in the destination, so links to
+			// targets outside of it are skipped and the others made
+			// relative to stay below the destination.
+			target := resolveSymlink(dir, hdr.Linkname)
+			if !withinPrefix(target, prefix) {
+				return nil
+			}
+			rel, err := filepath.Rel(filepath.FromSlash("/"+dir), filepath.FromSlash("/"+target))
+			if err != nil {
+				return err
+			}
+			hdr.Linkname = rel
+		case tar.TypeLink:
+			// Targets outside of the prefix aren't extracted.
+			hdr.Linkname = strings.Trim(path.Clean("/"+hdr.Linkname), "/")
+			if !withinPrefix(hdr.Linkname, prefix) {
+				return nil
+			}
+		}
+		if hdr.Typeflag != tar.TypeDir {
+			// Replace whatever previous layers had at this path.
+			if err := checkNoSymlinkParents(root, filepath.FromSlash(name)); err != nil {
+				return err
+			}
+			if err := os.RemoveAll(filepath.Join(root, filepath.FromSlash(name))); err != nil {
+				return err
+			}
+		}
+		return extractTarEntry(tr, hdr, filepath.FromSlash(name), root)
+	})
+}
+
+// resolveSymlink returns the path in the image a symlink in dir points to.
+// Like inside of a container, absolute targets are relative to the root of
+// the image and targets can't escape it.
+func resolveSymlink(dir, target string) string {
+	if path.IsAbs(target) {
+		return strings.Trim(path.Clean(target), "/")
+	}
+	return strings.Trim(path.Join("/", dir, target), "/")
+}
+
+// withinPrefix returns true if name is prefix or below it.
+func withinPrefix(name, prefix string) bool {
+	return prefix == "" || name == prefix || strings.HasPrefix(name, prefix+"/")
+}
+
+// overlapsPrefix returns true if name is within prefix or one of its parents.
+func overlapsPrefix(name, prefix string) bool {
+	return withinPrefix(name, prefix) || name == "" || strings.HasPrefix(prefix, name+"/")
+}
+
+// clearDir removes all entries in dir but keeps dir itself.
+func clearDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package initializer
+
+import (
+	"archive/tar"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDockerImageDownloader(t *testing.T) {
+	registry := newFakeRegistry("team/agent")
+	var (
+		layerType = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+		config    = registry.pushBlob("application/vnd.docker.container.image.v1+json", []byte("{}"), nil)
+		base      = registry.pushBlob(layerType, tarGz(t,
+			tarEntry{name: "etc/passwd", content: "root"},
+			tarEntry{name: "app/", typeflag: tar.TypeDir},
+			tarEntry{name: "app/main.py", content: "v1"},
+			tarEntry{name: "app/old.txt", content: "old"},
+			tarEntry{name: "app/cache/x", content: "x"},
+		), nil)
+		update = registry.pushBlob(layerType, tarGz(t,
+			tarEntry{name: "app/.wh.old.txt"},
+			tarEntry{name: "app/cache/.wh..wh..opq"},
+			tarEntry{name: "app/cache/y", content: "y"},
+			tarEntry{name: "app/python", typeflag: tar.TypeSymlink, linkname: "/usr/bin/python3"},
+			tarEntry{name: "app/lib/main.py", typeflag: tar.TypeSymlink, linkname: "/app/main.py"},
+			tarEntry{name: "app/escape", typeflag: tar.TypeSymlink, linkname: "../../../../app/cache"},
+			tarEntry{name: "app/passwd", typeflag: tar.TypeLink, linkname: "etc/passwd"},
+		), nil)
+		fix = registry.pushBlob(layerType, tarGz(t,
+			tarEntry{name: "./app/main.py", content: "v2"},
+		), nil)
+		arm = registry.pushBlob(layerType, tarGz(t, tarEntry{name: "app/main.py", content: "arm"}), nil)
+	)
+	amd64Digest := registry.push("amd64", ociManifest{MediaType: dockerManifestMediaType, Config: config, Layers: []ociDescriptor{base, update, fix}})
+	arm64Digest := registry.push("arm64", ociManifest{MediaType: dockerManifestMediaType, Config: config, Layers: []ociDescriptor{arm}})
+
+	index := ociManifest{MediaType: dockerListMediaType}
+	for _, m := range []struct{ digest, arch string }{{arm64Digest, "arm64"}, {amd64Digest, "amd64"}} {
+		desc := ociDescriptor{MediaType: dockerManifestMediaType, Digest: m.digest}
+		desc.Platform = &struct {
+			OS           string `json:"os"`
+			Architecture string `json:"architecture"`
+			Variant      string `json:"variant,omitempty"`
+		}{OS: "linux", Architecture: m.arch}
+		index.Manifests = append(index.Manifests, desc)
+	}
+	registry.push("v1", index)
+
+	server := httptest.NewServer(registry)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	for _, tc := range []struct {
+		name        string
+		source      string
+		expected    map[string]string
+		expectedErr string
+	}{
+		{
+			name:     "index",
+			source:   "docker-image://user:pass@" + host + "/team/agent:v1#plain_http=true&path=/app",
+			expected: map[string]string{"main.py": "v2", "cache/y": "y"},
+		},
+		{
+			name:     "platform",
+			source:   "docker-image://user:pass@" + host + "/team/agent:v1#plain_http=true&path=/app&platform=linux/arm64",
+			expected: map[string]string{"main.py": "arm"},
+		},
+		{
+			name:     "manifest",
+			source:   "docker-image://user:pass@" + host + "/team/agent:amd64#plain_http=true&path=app/cache",
+			expected: map[string]string{"y": "y"},
+		},
+		{
+			name:        "missing path",
+			source:      "docker-image://user:pass@" + host + "/team/agent:v1#plain_http=true&path=/srv",
+			expectedErr: "path /srv not found in image",
+		},
+		{
+			name:        "missing platform",
+			source:      "docker-image://user:pass@" + host + "/team/agent:v1#plain_http=true&platform=windows/amd64",
+			expectedErr: "no manifest for platform windows/amd64 in v1",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "dest")
+			err := (&dockerImageDownloader{HTTPClient: server.Client()}).Download(path, tc.source)
+			if tc.expectedErr != "" {
+				if err == nil || err.Error() != tc.expectedErr {
+					t.Fatalf("expected error %q, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.expected, readTree(t, path)); diff != "" {
+				t.Errorf("files mismatch (-want +got):\n%s", diff)
+			}
+			entries, err := os.ReadDir(filepath.Dir(path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 {
+				t.Errorf("temporary image root not removed: %v", entries)
+			}
+		})
+	}
+
+	t.Run("symlink", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "dest")
+		err := (&dockerImageDownloader{HTTPClient: server.Client()}).Download(path, "docker-image://user:pass@"+host+"/team/agent:v1#plain_http=true&path=/app")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Lstat(filepath.Join(path, "python")); !os.IsNotExist(err) {
+			t.Errorf("expected symlink outside of path to be skipped, got %v", err)
+		}
+		for name, expected := range map[string]string{"lib/main.py": "../main.py", "escape": "cache"} {
+			target, err := os.Readlink(filepath.Join(path, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if target != expected {
+				t.Errorf("unexpected target of %s: %s", name, target)
+			}
+		}
+	})
+}
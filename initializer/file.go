@@ -0,0 +1,116 @@
+package initializer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// fileDownloader copies files and directories already present in the
+// container, like pre-mounted volumes or node caches. Sources have the form
+// file:///mnt/cache/model.zip or just /mnt/cache/model.zip and need to be
+// below one of the allowed prefixes. Files are hardlinked if possible and
+// copied otherwise.
+type fileDownloader struct {
+	allowed []string
+}
+
+func (d *fileDownloader) Download(dest, source string) error {
+	u, err := url.Parse(source)
+	if err != nil {
+		return err
+	}
+	if u.Host != "" && u.Host != "localhost" {
+		return fmt.Errorf("invalid host %s: only local files are supported", u.Host)
+	}
+	path, err := d.resolve(u.Path)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("couldn't create directory %s: %w", filepath.Dir(dest), err)
+	}
+	return filepath.WalkDir(path, func(p string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(path, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+		switch {
+		case e.IsDir():
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return fmt.Errorf("couldn't create directory %s: %w", target, err)
+			}
+		case e.Type().IsRegular():
+			if err := linkOrCopy(p, target); err != nil {
+				return fmt.Errorf("couldn't copy %s to %s: %w", p, target, err)
+			}
+		default:
+			// Symlinks below the source might point outside of the allowed
+			// prefixes, so they are skipped like devices and sockets.
+		}
+		return nil
+	})
+}
+
+// resolve returns path with all symlinks resolved if it is below one of the
+// allowed prefixes.
+func (d *fileDownloader) resolve(path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("invalid path %s: needs to be an absolute path", path)
+	}
+	resolved, err := filepath.EvalSymlinks(filepath.Clean(path))
+	if err != nil {
+		return "", fmt.Errorf("couldn't resolve %s: %w", path, err)
+	}
+	for _, prefix := range d.allowed {
+		prefix, err := filepath.EvalSymlinks(prefix)
+		if err != nil {
+			continue
+		}
+		if rel, err := filepath.Rel(prefix, resolved); err == nil && (rel == "." || filepath.IsLocal(rel)) {
+			return resolved, nil
+		}
+	}
+	if len(d.allowed) == 0 {
+		return "", fmt.Errorf("invalid path %s: file sources are disabled, no readable prefixes are allowed", path)
+	}
+	return "", fmt.Errorf("invalid path %s: only paths below %s are allowed", path, strings.Join(d.allowed, ", "))
+}
+
+// linkOrCopy hardlinks src to dest, falling back to copying it if both aren't
+// on the same filesystem.
+func linkOrCopy(src, dest string) error {
+	if err := os.Remove(dest); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := os.Link(src, dest); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,140 @@
+package initializer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFileDownloader(t *testing.T) {
+	var (
+		allowed = t.TempDir()
+		other   = t.TempDir()
+	)
+	for name, content := range map[string]string{
+		filepath.Join(allowed, "model.bin"):        "weights",
+		filepath.Join(allowed, "dir", "a"):         "a",
+		filepath.Join(allowed, "dir", "sub", "b"):  "b",
+		filepath.Join(other, "secret"):             "secret",
+		filepath.Join(other, "nested", "password"): "password",
+	} {
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(other, "secret"), filepath.Join(allowed, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(other, "nested"), filepath.Join(allowed, "dir", "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name        string
+		allowed     []string
+		source      string
+		expected    map[string]string
+		expectedErr string
+	}{
+		{
+			name:     "file url",
+			allowed:  []string{allowed},
+			source:   "file://" + filepath.Join(allowed, "model.bin"),
+			expected: map[string]string{"": "weights"},
+		},
+		{
+			name:     "absolute path",
+			allowed:  []string{other, allowed},
+			source:   filepath.Join(allowed, "model.bin"),
+			expected: map[string]string{"": "weights"},
+		},
+		{
+			name:     "directory skips symlinks",
+			allowed:  []string{allowed},
+			source:   "file://" + filepath.Join(allowed, "dir"),
+			expected: map[string]string{"a": "a", "sub/b": "b"},
+		},
+		{
+			name:        "outside of prefixes",
+			allowed:     []string{allowed},
+			source:      "file://" + filepath.Join(other, "secret"),
+			expectedErr: "invalid path " + filepath.Join(other, "secret") + ": only paths below " + allowed + " are allowed",
+		},
+		{
+			name:        "symlink out of prefixes",
+			allowed:     []string{allowed},
+			source:      "file://" + filepath.Join(allowed, "escape"),
+			expectedErr: "invalid path " + filepath.Join(allowed, "escape") + ": only paths below " + allowed + " are allowed",
+		},
+		{
+			name:        "parent of prefix",
+			allowed:     []string{filepath.Join(allowed, "dir")},
+			source:      "file://" + filepath.Join(allowed, "dir", ".."),
+			expectedErr: "invalid path " + filepath.Join(allowed, "dir", "..") + ": only paths below " + filepath.Join(allowed, "dir") + " are allowed",
+		},
+		{
+			name:        "no prefixes",
+			source:      "file://" + filepath.Join(allowed, "model.bin"),
+			expectedErr: "invalid path " + filepath.Join(allowed, "model.bin") + ": file sources are disabled, no readable prefixes are allowed",
+		},
+		{
+			name:        "remote host",
+			allowed:     []string{allowed},
+			source:      "file://example.com" + filepath.Join(allowed, "model.bin"),
+			expectedErr: "invalid host example.com: only local files are supported",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dest := filepath.Join(t.TempDir(), "dest")
+			err := (&fileDownloader{allowed: tc.allowed}).Download(dest, tc.source)
+			if tc.expectedErr != "" {
+				if err == nil || err.Error() != tc.expectedErr {
+					t.Fatalf("expected error %q, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := readTree(t, dest)
+			if content, ok := got["."]; ok {
+				got = map[string]string{"": content}
+			}
+			if diff := cmp.Diff(tc.expected, got); diff != "" {
+				t.Errorf("files mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestInitializerFileSourceWithProcessor(t *testing.T) {
+	testdata, err := filepath.Abs("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	init, err := NewInitializer(log.NewNopLogger(), map[string]string{"test": "file+zip://" + filepath.Join(testdata, "test.zip")}, nil, nil, root, WithFilePrefixes(testdata))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := init.Init(); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"test/foo":          "hello world\n",
+		"test/bar":          "something else\n",
+		"test/baz/bux/date": "Sa 29. Apr 13:22:23 CEST 2023\n",
+	}
+	if diff := cmp.Diff(expected, readTree(t, root)); diff != "" {
+		t.Errorf("files mismatch (-want +got):\n%s", diff)
+	}
+	if _, err := os.Stat(filepath.Join(testdata, "test.zip")); err != nil {
+		t.Errorf("source file was modified: %v", err)
+	}
+}
@@ -0,0 +1,231 @@
+package initializer
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	gcsDefaultEndpoint = "https://storage.googleapis.com"
+	gcsDefaultTokenURI = "https://oauth2.googleapis.com/token"
+	gcsReadOnlyScope   = "https://www.googleapis.com/auth/devstorage.read_only"
+)
+
+// gcsDownloader downloads objects from Google Cloud Storage. Sources have the
+// form gs://bucket/object and are downloaded recursively if the object is
+// empty or ends with a slash. The fragment configures the service account
+// credentials and the endpoint.
+type gcsDownloader struct {
+	HTTPClient *http.Client
+	now        func() time.Time
+}
+
+func newGCSDownloader(client *http.Client) *gcsDownloader {
+	return &gcsDownloader{HTTPClient: client, now: time.Now}
+}
+
+type gcsSource struct {
+	bucket      string
+	object      string
+	endpoint    string
+	credentials *gcsServiceAccount
+	token       string
+}
+
+// gcsServiceAccount is the relevant subset of a service account JSON key.
+type gcsServiceAccount struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+func parseGCSSource(source string) (*gcsSource, error) {
+	u, err := url.Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("bucket is missing")
+	}
+	src := &gcsSource{
+		bucket:   u.Host,
+		object:   strings.TrimPrefix(u.Path, "/"),
+		endpoint: gcsDefaultEndpoint,
+	}
+
+	values, err := url.ParseQuery(u.EscapedFragment())
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range values {
+		if len(v) != 1 {
+			return nil, fmt.Errorf("invalid fragment %s: only one value is supported", k)
+		}
+		switch k {
+		case "endpoint":
+			src.endpoint = strings.TrimSuffix(v[0], "/")
+		case "credentials":
+			src.credentials = &gcsServiceAccount{}
+			if err := json.Unmarshal([]byte(v[0]), src.credentials); err != nil {
+				return nil, fmt.Errorf("invalid credentials: %w", err)
+			}
+			if src.credentials.TokenURI == "" {
+				src.credentials.TokenURI = gcsDefaultTokenURI
+			}
+		default:
+			return nil, fmt.Errorf("invalid fragment %s: only endpoint and credentials are supported", k)
+		}
+	}
+	return src, nil
+}
+
+func (d *gcsDownloader) Download(path, source string) error {
+	src, err := parseGCSSource(source)
+	if err != nil {
+		return err
+	}
+	if src.credentials != nil {
+		src.token, err = d.accessToken(src.credentials)
+		if err != nil {
+			return fmt.Errorf("couldn't get access token for %s: %w", src.credentials.ClientEmail, err)
+		}
+	}
+	if src.object != "" && !strings.HasSuffix(src.object, "/") {
+		return d.downloadObject(path, src, src.object)
+	}
+
+	objects, err := d.list(src)
+	if err != nil {
+		return err
+	}
+	return downloadPrefix(path, src.object, objects, func(path, object string) error {
+		return d.downloadObject(path, src, object)
+	})
+}
+
+func (d *gcsDownloader) downloadObject(path string, src *gcsSource, object string) error {
+	u := src.endpoint + "/storage/v1/b/" + url.PathEscape(src.bucket) + "/o/" + url.PathEscape(object) + "?alt=media"
+	resp, err := d.get(u, src)
+	if err != nil {
+		return err
+	}
+	if err := saveResponse(path, resp); err != nil {
+		return fmt.Errorf("couldn't download gs://%s/%s: %w", src.bucket, object, err)
+	}
+	return nil
+}
+
+type gcsObjectList struct {
+	Items []struct {
+		Name string `json:"name"`
+	} `json:"items"`
+	NextPageToken string `json:"nextPageToken"`
+}
+
+// list returns all objects below the source prefix.
+func (d *gcsDownloader) list(src *gcsSource) ([]string, error) {
+	var (
+		objects []string
+		token   string
+	)
+	for {
+		query := url.Values{"prefix": {src.object}}
+		if token != "" {
+			query.Set("pageToken", token)
+		}
+		resp, err := d.get(src.endpoint+"/storage/v1/b/"+url.PathEscape(src.bucket)+"/o?"+query.Encode(), src)
+		if err != nil {
+			return nil, err
+		}
+		var list gcsObjectList
+		if err := decodeJSONResponse(resp, &list); err != nil {
+			return nil, fmt.Errorf("couldn't list gs://%s/%s: %w", src.bucket, src.object, err)
+		}
+		for _, item := range list.Items {
+			objects = append(objects, item.Name)
+		}
+		if list.NextPageToken == "" {
+			return objects, nil
+		}
+		token = list.NextPageToken
+	}
+}
+
+func (d *gcsDownloader) get(u string, src *gcsSource) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if src.token != "" {
+		req.Header.Set("Authorization", "Bearer "+src.token)
+	}
+	return d.HTTPClient.Do(req)
+}
+
+// accessToken exchanges a JWT signed by the service account for an OAuth2
+// access token.
+func (d *gcsDownloader) accessToken(sa *gcsServiceAccount) (string, error) {
+	block, _ := pem.Decode([]byte(sa.PrivateKey))
+	if block == nil {
+		return "", fmt.Errorf("invalid private key: no PEM data found")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("invalid private key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", fmt.Errorf("invalid private key: only RSA keys are supported")
+	}
+
+	now := d.now()
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   sa.ClientEmail,
+		"scope": gcsReadOnlyScope,
+		"aud":   sa.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := d.HTTPClient.PostForm(sa.TokenURI, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)},
+	})
+	if err != nil {
+		return "", err
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := decodeJSONResponse(resp, &token); err != nil {
+		return "", err
+	}
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("no access token in response")
+	}
+	return token.AccessToken, nil
+}
@@ -0,0 +1,144 @@
+package initializer
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeGCS serves objects like fake-gcs-server and issues access tokens for
+// JWTs signed by key.
+func fakeGCS(t *testing.T, key *rsa.PrivateKey, bucket string, objects map[string]string) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(r.FormValue("assertion"), ".")
+		if r.FormValue("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" || len(parts) != 3 {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, hash[:], signature); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"access_token": "access-token", "token_type": "Bearer"}`)
+	})
+	mux.HandleFunc("/storage/v1/b/"+bucket+"/o", func(w http.ResponseWriter, r *http.Request) {
+		var list gcsObjectList
+		for name := range objects {
+			if strings.HasPrefix(name, r.URL.Query().Get("prefix")) {
+				list.Items = append(list.Items, struct {
+					Name string `json:"name"`
+				}{name})
+			}
+		}
+		json.NewEncoder(w).Encode(list)
+	})
+	mux.HandleFunc("/storage/v1/b/"+bucket+"/o/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access-token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		content, ok := objects[strings.TrimPrefix(r.URL.Path, "/storage/v1/b/"+bucket+"/o/")]
+		if !ok || r.URL.Query().Get("alt") != "media" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, content)
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestGCSDownloader(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := fakeGCS(t, key, "bucket", map[string]string{
+		"model/weights.bin":      "weights",
+		"model/config/agent.yml": "config",
+		"other/file":             "other",
+	})
+	defer server.Close()
+
+	credentials, err := json.Marshal(gcsServiceAccount{
+		ClientEmail: "agent@project.iam.gserviceaccount.com",
+		PrivateKey:  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		TokenURI:    server.URL + "/token",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fragment := "#endpoint=" + url.QueryEscape(server.URL) + "&credentials=" + url.QueryEscape(string(credentials))
+
+	for _, tc := range []struct {
+		name        string
+		source      string
+		expected    map[string]string
+		expectedErr string
+	}{
+		{
+			name:     "object",
+			source:   "gs://bucket/model/weights.bin" + fragment,
+			expected: map[string]string{".": "weights"},
+		},
+		{
+			name:   "prefix",
+			source: "gs://bucket/model/" + fragment,
+			expected: map[string]string{
+				"weights.bin":      "weights",
+				"config/agent.yml": "config",
+			},
+		},
+		{
+			name:        "anonymous",
+			source:      "gs://bucket/model/weights.bin#endpoint=" + url.QueryEscape(server.URL),
+			expectedErr: "couldn't download gs://bucket/model/weights.bin: unexpected status code 401: unauthorized\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "dest")
+			err := newGCSDownloader(server.Client()).Download(path, tc.source)
+			if tc.expectedErr != "" {
+				if err == nil || err.Error() != tc.expectedErr {
+					t.Fatalf("expected error %q, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, expected := range tc.expected {
+				content, err := os.ReadFile(filepath.Join(path, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(content) != expected {
+					t.Errorf("unexpected content in %s: %q", name, content)
+				}
+			}
+		})
+	}
+}
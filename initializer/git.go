@@ -2,76 +2,272 @@ package initializer
 
 import (
 	"fmt"
-	"io"
 	"net/url"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 )
 
-// FIXME: The logWriter doesn't non-progress output well
-type logWriter struct {
-	logger log.Logger
+type gitDownloader struct {
+	progress   *logWriter
+	redactor   *redactor
+	ssh        string // ssh binary to use, defaults to ssh
+	tls        map[string]TLSConfig
+	proxy      *ProxyConfig
+	policy     *addressPolicy
+	hostPolicy *HostPolicy
+	// noRedirects disables redirects over http(s) since git can't apply
+	// the host and redirect policies to them.
+	noRedirects bool
 }
 
-func (l *logWriter) Write(p []byte) (n int, err error) {
-	s := 0
-	for i := 0; i < len(p); i++ {
-		if p[i] == '\n' {
-			l.logger.Log("msg", string(p[s:i]))
-			s = i + 1
-		}
+// NewGitDownloader returns a Downloader using the git binary. Any of the
+// secrets values are masked in the git output and returned errors.
+func NewGitDownloader(logger log.Logger, secrets map[string]string) Downloader {
+	r := newRedactor(secrets)
+	return &gitDownloader{
+		progress: newLogWriter(level.Info(logger), r),
+		redactor: r,
 	}
+}
 
-	if s < len(p) { // Check for remaining text after the last newline character
-		l.logger.Log("msg", string(p[s:]))
+// gitSource is a git url with the options parsed from its fragment.
+type gitSource struct {
+	url            *url.URL
+	ref            string
+	sshKey         string
+	knownHosts     string
+	allowedSigners string
+	keyring        string
+}
+
+func parseGitSource(urls string) (*gitSource, error) {
+	u, err := url.Parse(urls)
+	if err != nil {
+		return nil, err
 	}
 
-	return len(p), nil
-}
+	values, err := url.ParseQuery(u.EscapedFragment())
+	if err != nil {
+		return nil, err
+	}
 
-type gitDownloader struct {
-	progress io.Writer
-}
+	src := &gitSource{url: u, ref: "main"}
+	for k, v := range values {
+		if len(v) != 1 {
+			return nil, fmt.Errorf("invalid fragment %s: only one value is supported", k)
+		}
+		switch k {
+		case "ref":
+			src.ref = v[0]
+		case "ssh_key":
+			src.sshKey = v[0]
+		case "known_hosts":
+			src.knownHosts = v[0]
+		case "allowed_signers":
+			src.allowedSigners = v[0]
+		case "keyring":
+			src.keyring = v[0]
+		default:
+			return nil, fmt.Errorf("invalid fragment %s: only ref, ssh_key, known_hosts, allowed_signers and keyring are supported", k)
+		}
+	}
+	u.Fragment = ""
+	u.RawFragment = ""
 
-func NewGitDownloader(logger log.Logger) Downloader {
-	return &gitDownloader{
-		progress: &logWriter{level.Info(logger)},
+	if u.Scheme != "ssh" && (src.sshKey != "" || src.knownHosts != "") {
+		return nil, fmt.Errorf("ssh_key and known_hosts are only supported for ssh urls")
 	}
+	if src.allowedSigners != "" && src.keyring != "" {
+		return nil, fmt.Errorf("only one of allowed_signers and keyring is supported")
+	}
+	return src, nil
 }
 
 func (g *gitDownloader) Download(path, urls string) error {
-	u, err := url.Parse(urls)
+	return g.redactor.redactError(g.download(path, urls))
+}
+
+func (g *gitDownloader) download(path, urls string) error {
+	src, err := parseGitSource(urls)
 	if err != nil {
 		return err
 	}
+	u := src.url
 
-	values, err := url.ParseQuery(u.Fragment)
+	dir, err := os.MkdirTemp("", "git")
+	if err != nil {
+		return fmt.Errorf("couldn't create directory for git config: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	args, err := g.tlsArgs(dir)
 	if err != nil {
 		return err
 	}
-	if len(values) > 1 {
-		return fmt.Errorf("invalid fragment %s: only one ref is supported", u.Fragment)
+	var sshOptions []string
+	if g.noRedirects && (u.Scheme == "http" || u.Scheme == "https") {
+		// Redirects can't be checked against the host and redirect policies.
+		args = append(args, "-c", "http.followRedirects=false")
 	}
-
-	ref := "main"
-	for k, v := range values {
-		if k != "ref" {
-			return fmt.Errorf("invalid fragment %s: only ref is supported", k)
+	if g.policy != nil {
+		// git can't use the dialer, so the host is resolved and checked
+		// up front and git is pinned to the checked address.
+		ip, err := g.policy.resolve(u.Hostname())
+		if err != nil {
+			return err
 		}
-		if len(v) != 1 {
-			return fmt.Errorf("invalid fragment %s: only one ref is supported", k)
+		switch u.Scheme {
+		case "http", "https":
+			port := u.Port()
+			if port == "" {
+				port = map[string]string{"http": "80", "https": "443"}[u.Scheme]
+			}
+			addr := ip.String()
+			if ip.To4() == nil {
+				addr = "[" + addr + "]"
+			}
+			// A proxy would connect to the host itself, bypassing the
+			// checked address, so proxies are disabled.
+			args = append(args, "-c", "http.curloptResolve="+u.Hostname()+":"+port+":"+addr, "-c", "http.followRedirects=false", "-c", "http.proxy=")
+		case "ssh":
+			sshOptions = append(sshOptions, "-o", "HostName="+ip.String(), "-o", "HostKeyAlias="+u.Hostname())
 		}
-		ref = v[0]
 	}
-	u.Fragment = ""
-
-	cmd := exec.Command("git", "clone", "--depth", "1", "--branch", ref, u.String(), path)
+	args = append(args, "clone", "--depth", "1", "--branch", src.ref, u.String(), path)
+	cmd := exec.Command("git", args...)
 	cmd.Stdout = g.progress
 	cmd.Stderr = g.progress
-	if err := cmd.Run(); err != nil {
+	cmd.Env = os.Environ()
+	if g.policy != nil {
+		cmd.Env = withoutProxyEnv(cmd.Env)
+	} else if g.proxy != nil {
+		cmd.Env = append(cmd.Env, g.proxy.env()...)
+	}
+	if u.Scheme == "ssh" {
+		sshCommand, err := g.sshCommand(dir, src.sshKey, src.knownHosts, sshOptions...)
+		if err != nil {
+			return err
+		}
+		cmd.Env = append(cmd.Env, "GIT_SSH_COMMAND="+sshCommand)
+	}
+	err = cmd.Run()
+	g.progress.Flush()
+	if err != nil {
 		return fmt.Errorf("couldn't clone repository: %w", err)
 	}
+	if src.allowedSigners != "" || src.keyring != "" {
+		if err := verifyClone(dir, path, src); err != nil {
+			os.RemoveAll(path)
+			return err
+		}
+	}
+	if g.hostPolicy != nil {
+		return g.hostPolicy.checkSubmodules(path, u)
+	}
 	return nil
 }
+
+// verifyClone checks that the annotated tag or, for branches and lightweight
+// tags, the commit checked out at path is signed by one of the
+// allowed_signers or a key of the keyring, which are written to dir.
+func verifyClone(dir, path string, src *gitSource) error {
+	args := []string{"-C", path}
+	env := os.Environ()
+	if src.allowedSigners != "" {
+		signersFile := filepath.Join(dir, "allowed_signers")
+		if err := writeSecretFile(signersFile, src.allowedSigners); err != nil {
+			return fmt.Errorf("couldn't write allowed_signers: %w", err)
+		}
+		args = append(args, "-c", "gpg.ssh.allowedSignersFile="+signersFile)
+	} else {
+		home := filepath.Join(dir, "gnupg")
+		if err := os.Mkdir(home, 0700); err != nil {
+			return fmt.Errorf("couldn't create gpg home: %w", err)
+		}
+		keyringFile := filepath.Join(dir, "keyring.asc")
+		if err := writeSecretFile(keyringFile, src.keyring); err != nil {
+			return fmt.Errorf("couldn't write keyring: %w", err)
+		}
+		if out, err := exec.Command("gpg", "--batch", "--homedir", home, "--import", keyringFile).CombinedOutput(); err != nil {
+			return fmt.Errorf("couldn't import keyring: %w: %s", err, strings.TrimSpace(string(out)))
+		}
+		env = append(env, "GNUPGHOME="+home)
+	}
+
+	verify := []string{"verify-commit", "HEAD"}
+	if out, err := exec.Command("git", "-C", path, "cat-file", "-t", "refs/tags/"+src.ref).Output(); err == nil && strings.TrimSpace(string(out)) == "tag" {
+		verify = []string{"verify-tag", "refs/tags/" + src.ref}
+	}
+	cmd := exec.Command("git", append(args, verify...)...)
+	cmd.Env = env
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s %s: %s", ErrGitSignature, verify[0], src.ref, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// tlsArgs writes the CA bundles and client certificates to dir and returns
+// the git options using them.
+func (g *gitDownloader) tlsArgs(dir string) ([]string, error) {
+	hosts := make([]string, 0, len(g.tls))
+	for host := range g.tls {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	var args []string
+	for _, host := range hosts {
+		a, err := g.tls[host].gitArgs(dir, host)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tls config for %s: %w", host, err)
+		}
+		args = append(args, a...)
+	}
+	return args, nil
+}
+
+// sshCommand writes the key and known hosts to dir and returns the command
+// git should use to connect to the remote.
+func (g *gitDownloader) sshCommand(dir, sshKey, knownHosts string, options ...string) (string, error) {
+	ssh := g.ssh
+	if ssh == "" {
+		ssh = "ssh"
+	}
+	args := append([]string{ssh, "-o", "BatchMode=yes", "-o", "StrictHostKeyChecking=yes"}, options...)
+	if sshKey != "" {
+		keyFile := filepath.Join(dir, "id")
+		if err := writeSecretFile(keyFile, sshKey); err != nil {
+			return "", fmt.Errorf("couldn't write ssh key: %w", err)
+		}
+		args = append(args, "-o", "IdentitiesOnly=yes", "-i", keyFile)
+	}
+	if knownHosts != "" {
+		knownHostsFile := filepath.Join(dir, "known_hosts")
+		if err := writeSecretFile(knownHostsFile, knownHosts); err != nil {
+			return "", fmt.Errorf("couldn't write known_hosts: %w", err)
+		}
+		args = append(args, "-o", "UserKnownHostsFile="+knownHostsFile)
+	}
+	for i, arg := range args {
+		args[i] = shellQuote(arg)
+	}
+	return strings.Join(args, " "), nil
+}
+
+// writeSecretFile writes content to a file only readable by the current user.
+// A trailing newline is added if missing since ssh refuses keys without one.
+func writeSecretFile(path, content string) error {
+	if !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	return os.WriteFile(path, []byte(content), 0600)
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
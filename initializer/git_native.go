@@ -0,0 +1,296 @@
+package initializer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	gitclient "github.com/go-git/go-git/v5/plumbing/transport/client"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	// ErrGitAuth is returned when the remote rejected the credentials.
+	ErrGitAuth = errors.New("git authentication failed")
+	// ErrGitRefNotFound is returned when the requested ref doesn't exist on the remote.
+	ErrGitRefNotFound = errors.New("git ref not found")
+	// ErrGitNetwork is returned when the remote couldn't be reached.
+	ErrGitNetwork = errors.New("git network error")
+	// ErrGitSignature is returned when the cloned commit or tag isn't signed
+	// by one of the trusted keys.
+	ErrGitSignature = errors.New("git signature verification failed")
+)
+
+// nativeGitDownloader clones repositories using go-git, so no git binary is
+// required.
+type nativeGitDownloader struct {
+	progress   *logWriter
+	redactor   *redactor
+	httpClient *http.Client
+	policy     *addressPolicy
+	hostPolicy *HostPolicy
+}
+
+// NewNativeGitDownloader returns a Downloader using go-git. Any of the
+// secrets values are masked in the progress output and returned errors.
+func NewNativeGitDownloader(logger log.Logger, secrets map[string]string) Downloader {
+	r := newRedactor(secrets)
+	return &nativeGitDownloader{
+		progress: newLogWriter(level.Info(logger), r),
+		redactor: r,
+	}
+}
+
+func (g *nativeGitDownloader) Download(path, urls string) error {
+	return g.redactor.redactError(g.download(path, urls))
+}
+
+func (g *nativeGitDownloader) download(path, urls string) error {
+	src, err := parseGitSource(urls)
+	if err != nil {
+		return err
+	}
+
+	if g.policy != nil && src.url.Scheme == "ssh" {
+		// http connections are checked by the dialer of the http client.
+		// go-git dials ssh itself, so the host is checked up front and the
+		// connected address again before authenticating.
+		if _, err := g.policy.resolve(src.url.Hostname()); err != nil {
+			return err
+		}
+	}
+	ctx := context.Background()
+	if g.httpClient != nil {
+		installGitHTTP()
+		ctx = context.WithValue(ctx, gitHTTPClientKey{}, g.httpClient)
+	}
+
+	auth, cleanup, err := g.auth(src)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	ref, err := g.resolveRef(ctx, src, auth)
+	if err != nil {
+		return err
+	}
+
+	repo, err := git.PlainCloneContext(ctx, path, false, &git.CloneOptions{
+		URL:           src.url.String(),
+		Auth:          auth,
+		ReferenceName: ref,
+		SingleBranch:  true,
+		Depth:         1,
+		Progress:      g.progress,
+	})
+	g.progress.Flush()
+	if err != nil {
+		return fmt.Errorf("couldn't clone repository: %w", classifyGitError(err))
+	}
+	if src.allowedSigners != "" || src.keyring != "" {
+		if err := verifyNative(repo, ref, src); err != nil {
+			os.RemoveAll(path)
+			return err
+		}
+	}
+	if g.hostPolicy != nil {
+		return g.hostPolicy.checkSubmodules(path, src.url)
+	}
+	return nil
+}
+
+// verifyNative checks that the annotated tag or, for branches and
+// lightweight tags, the commit of ref is signed by a key in the keyring.
+// go-git only supports PGP signatures.
+func verifyNative(repo *git.Repository, ref plumbing.ReferenceName, src *gitSource) error {
+	if src.allowedSigners != "" {
+		return fmt.Errorf("%w: allowed_signers is only supported by the cli backend", ErrGitSignature)
+	}
+	r, err := repo.Reference(ref, true)
+	if err != nil {
+		return fmt.Errorf("couldn't resolve %s: %w", ref, err)
+	}
+	if tag, err := repo.TagObject(r.Hash()); err == nil {
+		if _, err := tag.Verify(src.keyring); err != nil {
+			return fmt.Errorf("%w: tag %s: %v", ErrGitSignature, src.ref, err)
+		}
+		return nil
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return fmt.Errorf("couldn't resolve HEAD: %w", err)
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return fmt.Errorf("couldn't read commit %s: %w", head.Hash(), err)
+	}
+	if _, err := commit.Verify(src.keyring); err != nil {
+		return fmt.Errorf("%w: commit %s: %v", ErrGitSignature, commit.Hash, err)
+	}
+	return nil
+}
+
+// resolveRef looks up ref on the remote since, like git clone --branch, it
+// may refer to either a branch or a tag.
+func (g *nativeGitDownloader) resolveRef(ctx context.Context, src *gitSource, auth transport.AuthMethod) (plumbing.ReferenceName, error) {
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: "origin",
+		URLs: []string{src.url.String()},
+	})
+	refs, err := remote.ListContext(ctx, &git.ListOptions{Auth: auth})
+	if err != nil {
+		return "", fmt.Errorf("couldn't list remote refs: %w", classifyGitError(err))
+	}
+	for _, name := range []plumbing.ReferenceName{
+		plumbing.NewBranchReferenceName(src.ref),
+		plumbing.NewTagReferenceName(src.ref),
+	} {
+		for _, r := range refs {
+			if r.Name() == name {
+				return name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrGitRefNotFound, src.ref)
+}
+
+// gitHTTPClientKey is the context key of the http client used for the
+// requests of a clone.
+type gitHTTPClientKey struct{}
+
+var installGitHTTPOnce sync.Once
+
+// installGitHTTP replaces the http transports of go-git, which can only be
+// configured globally, with ones using the http client from the context of
+// each request, so downloaders don't share their clients.
+func installGitHTTP() {
+	installGitHTTPOnce.Do(func() {
+		client := githttp.NewClient(&http.Client{
+			Transport: contextTransport{},
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if c, ok := req.Context().Value(gitHTTPClientKey{}).(*http.Client); ok && c.CheckRedirect != nil {
+					return c.CheckRedirect(req, via)
+				}
+				return DefaultRedirectPolicy.check(req, via)
+			},
+		})
+		gitclient.InstallProtocol("https", client)
+		gitclient.InstallProtocol("http", client)
+	})
+}
+
+// contextTransport sends requests with the transport of the http client in
+// their context, or the default transport.
+type contextTransport struct{}
+
+func (contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if c, ok := req.Context().Value(gitHTTPClientKey{}).(*http.Client); ok && c.Transport != nil {
+		return c.Transport.RoundTrip(req)
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// auth returns the auth method for src. The returned cleanup function
+// removes any files written for it.
+func (g *nativeGitDownloader) auth(src *gitSource) (transport.AuthMethod, func(), error) {
+	noop := func() {}
+	u := src.url
+	switch u.Scheme {
+	case "http", "https":
+		if u.User == nil {
+			return nil, noop, nil
+		}
+		password, _ := u.User.Password()
+		auth := &githttp.BasicAuth{Username: u.User.Username(), Password: password}
+		u.User = nil
+		return auth, noop, nil
+	case "ssh":
+		user := "git"
+		if u.User != nil {
+			user = u.User.Username()
+		}
+		var (
+			auth transport.AuthMethod
+			err  error
+		)
+		if src.sshKey != "" {
+			auth, err = gitssh.NewPublicKeys(user, []byte(src.sshKey), "")
+		} else {
+			auth, err = gitssh.NewSSHAgentAuth(user)
+		}
+		if err != nil {
+			return nil, noop, fmt.Errorf("couldn't load ssh key: %w", err)
+		}
+		if src.knownHosts == "" {
+			if g.policy != nil {
+				// go-git falls back to the default known_hosts files.
+				callback, err := gitssh.NewKnownHostsCallback()
+				if err != nil {
+					return nil, noop, fmt.Errorf("couldn't load known_hosts: %w", err)
+				}
+				setHostKeyCallback(auth, g.policy.hostKeyCallback(callback))
+			}
+			return auth, noop, nil
+		}
+
+		dir, err := os.MkdirTemp("", "git-ssh")
+		if err != nil {
+			return nil, noop, fmt.Errorf("couldn't create directory for ssh config: %w", err)
+		}
+		cleanup := func() { os.RemoveAll(dir) }
+		knownHostsFile := filepath.Join(dir, "known_hosts")
+		if err := writeSecretFile(knownHostsFile, src.knownHosts); err != nil {
+			cleanup()
+			return nil, noop, fmt.Errorf("couldn't write known_hosts: %w", err)
+		}
+		callback, err := gitssh.NewKnownHostsCallback(knownHostsFile)
+		if err != nil {
+			cleanup()
+			return nil, noop, fmt.Errorf("couldn't parse known_hosts: %w", err)
+		}
+		if g.policy != nil {
+			callback = g.policy.hostKeyCallback(callback)
+		}
+		setHostKeyCallback(auth, callback)
+		return auth, cleanup, nil
+	}
+	return nil, noop, nil
+}
+
+func setHostKeyCallback(auth transport.AuthMethod, callback ssh.HostKeyCallback) {
+	switch a := auth.(type) {
+	case *gitssh.PublicKeys:
+		a.HostKeyCallback = callback
+	case *gitssh.PublicKeysCallback:
+		a.HostKeyCallback = callback
+	}
+}
+
+func classifyGitError(err error) error {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, transport.ErrAuthenticationRequired),
+		errors.Is(err, transport.ErrAuthorizationFailed):
+		return fmt.Errorf("%w: %v", ErrGitAuth, err)
+	case errors.Is(err, git.NoMatchingRefSpecError{}):
+		return fmt.Errorf("%w: %v", ErrGitRefNotFound, err)
+	case errors.As(err, &netErr):
+		return fmt.Errorf("%w: %v", ErrGitNetwork, err)
+	}
+	return err
+}
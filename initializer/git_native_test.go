@@ -0,0 +1,139 @@
+package initializer
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestNativeGitDownloader(t *testing.T) {
+	repo := newTestRepo(t)
+	if out, err := exec.Command("git", "-C", repo, "tag", "v1", "main").CombinedOutput(); err != nil {
+		t.Fatalf("git tag failed: %s: %s", err, out)
+	}
+
+	for _, tc := range []struct {
+		name        string
+		source      string
+		expectedErr error
+	}{
+		{
+			name:   "default ref",
+			source: repo,
+		},
+		{
+			name:   "branch",
+			source: repo + "#ref=main",
+		},
+		{
+			name:   "tag",
+			source: repo + "#ref=v1",
+		},
+		{
+			name:        "missing ref",
+			source:      repo + "#ref=nope",
+			expectedErr: ErrGitRefNotFound,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				path       = filepath.Join(t.TempDir(), "clone")
+				downloader = NewNativeGitDownloader(log.NewNopLogger(), nil)
+			)
+			err := downloader.Download(path, tc.source)
+			if tc.expectedErr != nil {
+				if !errors.Is(err, tc.expectedErr) {
+					t.Fatalf("expected %v, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, err := os.ReadFile(filepath.Join(path, "foo"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != "hello world\n" {
+				t.Errorf("unexpected content: %q", content)
+			}
+		})
+	}
+}
+
+func TestNativeGitDownloaderErrors(t *testing.T) {
+	unauthorized := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer unauthorized.Close()
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	for _, tc := range []struct {
+		name        string
+		source      string
+		expectedErr error
+	}{
+		{
+			name:        "auth",
+			source:      unauthorized.URL + "/repo.git",
+			expectedErr: ErrGitAuth,
+		},
+		{
+			name:        "network",
+			source:      closed.URL + "/repo.git",
+			expectedErr: ErrGitNetwork,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			downloader := NewNativeGitDownloader(log.NewNopLogger(), nil)
+			err := downloader.Download(filepath.Join(t.TempDir(), "clone"), tc.source)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("expected %v, got %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestNativeGitDownloaderHTTPClients(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		seen = make(map[string]string)
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen[strings.Split(r.URL.Path, "/")[1]] = r.Header.Get("X-Client")
+		mu.Unlock()
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	var wg sync.WaitGroup
+	for _, name := range []string{"a", "b"} {
+		client := &http.Client{Transport: &hostHeaderTransport{
+			transport: http.DefaultTransport,
+			headers:   map[string]http.Header{host: {"X-Client": {name}}},
+		}}
+		downloader := &nativeGitDownloader{progress: newLogWriter(log.NewNopLogger(), newRedactor(nil)), redactor: newRedactor(nil), httpClient: client}
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			downloader.Download(filepath.Join(t.TempDir(), name), server.URL+"/"+name+"/repo.git")
+		}(name)
+	}
+	wg.Wait()
+	if diff := cmp.Diff(map[string]string{"a": "a", "b": "b"}, seen); diff != "" {
+		t.Fatalf("requests didn't use the client of their downloader (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,149 @@
+package initializer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+)
+
+// newTestRepo creates a bare repository with a single commit on main.
+func newTestRepo(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	var (
+		dir  = t.TempDir()
+		work = filepath.Join(dir, "work")
+		bare = filepath.Join(dir, "repo.git")
+	)
+	if err := os.Mkdir(work, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(work, "foo"), []byte("hello world\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"-C", work, "init", "-b", "main"},
+		{"-C", work, "add", "foo"},
+		{"-C", work, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-m", "initial"},
+		{"clone", "--bare", work, bare},
+	} {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %s: %s", args, err, out)
+		}
+	}
+	return bare
+}
+
+// fakeSSH writes a stand-in for ssh that records its key and known_hosts
+// files and runs the requested command locally.
+func fakeSSH(t *testing.T) (ssh, record string) {
+	t.Helper()
+	dir := t.TempDir()
+	ssh = filepath.Join(dir, "ssh")
+	record = filepath.Join(dir, "record")
+	script := `#!/bin/sh
+while [ $# -gt 1 ]; do
+	case "$1" in
+	-i) stat -c %a "$2" >> ` + record + `; cat "$2" >> ` + record + `; shift ;;
+	UserKnownHostsFile=*) cat "${1#UserKnownHostsFile=}" >> ` + record + ` ;;
+	StrictHostKeyChecking=*) echo "$1" >> ` + record + ` ;;
+	esac
+	shift
+done
+exec sh -c "$1"
+`
+	if err := os.WriteFile(ssh, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return ssh, record
+}
+
+func TestGitDownloaderSSH(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ssh requires a posix shell")
+	}
+	var (
+		repo        = newTestRepo(t)
+		ssh, record = fakeSSH(t)
+		path        = filepath.Join(t.TempDir(), "clone")
+		downloader  = &gitDownloader{progress: newLogWriter(log.NewNopLogger(), nil), ssh: ssh}
+	)
+
+	tmpBefore, err := filepath.Glob(filepath.Join(os.TempDir(), "git-ssh*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := "ssh://git@example.com" + repo + "#ref=main&ssh_key=my-key%0Asecond+line&known_hosts=example.com+ssh-ed25519+AAAA"
+	if err := downloader.Download(path, source); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(path, "foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "hello world\n" {
+		t.Errorf("unexpected content: %q", content)
+	}
+
+	recorded, err := os.ReadFile(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "StrictHostKeyChecking=yes\n600\nmy-key\nsecond line\nexample.com ssh-ed25519 AAAA\n"
+	if string(recorded) != expected {
+		t.Errorf("unexpected ssh invocation:\nwant %q\ngot  %q", expected, recorded)
+	}
+
+	tmpAfter, err := filepath.Glob(filepath.Join(os.TempDir(), "git-ssh*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tmpAfter) != len(tmpBefore) {
+		t.Errorf("ssh config not removed: %s", strings.Join(tmpAfter, ", "))
+	}
+}
+
+func TestGitDownloaderSSHOptionsRequireSSH(t *testing.T) {
+	downloader := &gitDownloader{progress: newLogWriter(log.NewNopLogger(), nil)}
+	err := downloader.Download(t.TempDir(), "https://example.com/repo.git#ssh_key=foo")
+	if err == nil || err.Error() != "ssh_key and known_hosts are only supported for ssh urls" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestGitDownloaderRedactsSecrets(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	var (
+		sw         = &sliceWriter{}
+		downloader = NewGitDownloader(log.NewLogfmtLogger(sw), map[string]string{"token": "s3cr3t-t0ken"})
+		source     = strings.Replace(closed.URL, "http://", "http://user:s3cr3t-t0ken@", 1) + "/repo.git"
+	)
+	err := downloader.Download(filepath.Join(t.TempDir(), "clone"), source)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if len(sw.slices) == 0 {
+		t.Fatal("expected git output to be logged")
+	}
+	for _, line := range append(sw.slices, err.Error()) {
+		if strings.Contains(line, "s3cr3t-t0ken") {
+			t.Errorf("secret not redacted: %s", line)
+		}
+	}
+}
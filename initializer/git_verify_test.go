@@ -0,0 +1,135 @@
+package initializer
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/log"
+)
+
+// newSignedRepo creates a bare repository with a commit signed with an ssh
+// key on main, one signed with a gpg key on pgp, a signed tag for each key
+// and an unsigned commit. It returns the repository and the allowed signers
+// and keyring trusting the keys.
+func newSignedRepo(t *testing.T) (repo, allowedSigners, keyring string) {
+	t.Helper()
+	for _, bin := range []string{"git", "ssh-keygen", "gpg"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			t.Skipf("%s not found", bin)
+		}
+	}
+	var (
+		dir  = t.TempDir()
+		work = filepath.Join(dir, "work")
+		key  = filepath.Join(dir, "id_ed25519")
+	)
+	repo = filepath.Join(dir, "repo.git")
+	// gpg-agent's socket path needs to be short.
+	gnupg, err := os.MkdirTemp("", "gpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd := exec.Command("gpgconf", "--kill", "all")
+		cmd.Env = append(os.Environ(), "GNUPGHOME="+gnupg)
+		cmd.Run()
+		os.RemoveAll(gnupg)
+	})
+	run := func(name string, args ...string) string {
+		cmd := exec.Command(name, args...)
+		cmd.Env = append(os.Environ(), "GNUPGHOME="+gnupg)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("%s %v failed: %s: %s", name, args, err, out)
+		}
+		return string(out)
+	}
+	user := []string{"-C", work, "-c", "user.name=test", "-c", "user.email=test@example.com"}
+	ssh := append(user, "-c", "gpg.format=ssh", "-c", "user.signingkey="+key)
+	pgp := append(user, "-c", "user.signingkey=test@example.com")
+
+	run("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-f", key)
+	run("gpg", "--batch", "--passphrase", "", "--quick-gen-key", "test <test@example.com>", "ed25519", "sign", "never")
+	if err := os.MkdirAll(work, 0755); err != nil {
+		t.Fatal(err)
+	}
+	run("git", "-C", work, "init", "-b", "main")
+	run("git", append(user, "commit", "--allow-empty", "-m", "unsigned")...)
+	run("git", "-C", work, "branch", "unsigned")
+	run("git", append(pgp, "commit", "--allow-empty", "-S", "-m", "pgp signed")...)
+	run("git", "-C", work, "branch", "pgp")
+	run("git", append(pgp, "tag", "-s", "-m", "pgp signed", "pgp-tag")...)
+	run("git", append(ssh, "commit", "--allow-empty", "-S", "-m", "ssh signed")...)
+	run("git", append(ssh, "tag", "-s", "-m", "ssh signed", "ssh-tag", "unsigned")...)
+	run("git", "-C", work, "tag", "lightweight", "unsigned")
+	run("git", "clone", "--bare", work, repo)
+
+	pub, err := os.ReadFile(key + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo, "test@example.com " + string(pub), run("gpg", "--armor", "--export", "test@example.com")
+}
+
+func TestGitSignatureVerification(t *testing.T) {
+	repo, allowedSigners, keyring := newSignedRepo(t)
+	otherKey := filepath.Join(t.TempDir(), "id_ed25519")
+	if out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-f", otherKey).CombinedOutput(); err != nil {
+		t.Fatalf("ssh-keygen failed: %s: %s", err, out)
+	}
+	otherPub, err := os.ReadFile(otherKey + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ssh := "&allowed_signers=" + url.QueryEscape(allowedSigners)
+	pgp := "&keyring=" + url.QueryEscape(keyring)
+
+	for _, tc := range []struct {
+		name      string
+		fragment  string
+		native    bool
+		expectErr bool
+	}{
+		{name: "ssh commit", fragment: "ref=main" + ssh},
+		{name: "ssh tag", fragment: "ref=ssh-tag" + ssh},
+		{name: "pgp commit", fragment: "ref=pgp" + pgp},
+		{name: "pgp tag", fragment: "ref=pgp-tag" + pgp},
+		{name: "unsigned commit", fragment: "ref=unsigned" + ssh, expectErr: true},
+		{name: "lightweight tag of unsigned commit", fragment: "ref=lightweight" + pgp, expectErr: true},
+		{name: "wrong key type", fragment: "ref=pgp" + ssh, expectErr: true},
+		{name: "untrusted key", fragment: "ref=main&allowed_signers=" + url.QueryEscape("test@example.com "+string(otherPub)), expectErr: true},
+		{name: "native pgp commit", fragment: "ref=pgp" + pgp, native: true},
+		{name: "native pgp tag", fragment: "ref=pgp-tag" + pgp, native: true},
+		{name: "native unsigned commit", fragment: "ref=unsigned" + pgp, native: true, expectErr: true},
+		{name: "native ssh", fragment: "ref=main" + ssh, native: true, expectErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "clone")
+			downloader := NewGitDownloader(log.NewNopLogger(), nil)
+			if tc.native {
+				downloader = NewNativeGitDownloader(log.NewNopLogger(), nil)
+			}
+			err := downloader.Download(path, repo+"#"+tc.fragment)
+			if !tc.expectErr {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrGitSignature) {
+				t.Fatalf("expected signature verification to fail, got %v", err)
+			}
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Errorf("expected unverified clone to be removed, got %v", err)
+			}
+		})
+	}
+
+	if _, err := parseGitSource(repo + "#allowed_signers=a&keyring=b"); err == nil {
+		t.Error("expected error for allowed_signers and keyring")
+	}
+}
@@ -0,0 +1,158 @@
+package initializer
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+const githubDefaultAPI = "https://api.github.com"
+
+// githubReleaseDownloader downloads release assets from GitHub. Sources have
+// the form github-release://owner/repo@tag/asset-glob where tag can be
+// latest. The fragment configures the token and the api base url for GitHub
+// Enterprise.
+type githubReleaseDownloader struct {
+	HTTPClient *http.Client
+}
+
+type githubReleaseSource struct {
+	owner string
+	repo  string
+	tag   string
+	glob  string
+	api   string
+	token string
+}
+
+type githubRelease struct {
+	TagName string `json:"tag_name"`
+	Assets  []struct {
+		Name string `json:"name"`
+		URL  string `json:"url"`
+	} `json:"assets"`
+}
+
+func parseGitHubReleaseSource(source string) (*githubReleaseSource, error) {
+	u, err := url.Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	src := &githubReleaseSource{
+		owner: u.Host,
+		api:   githubDefaultAPI,
+	}
+	repo, rest, ok := strings.Cut(strings.TrimPrefix(u.Path, "/"), "@")
+	i := strings.LastIndex(rest, "/")
+	if !ok || i < 0 {
+		return nil, fmt.Errorf("invalid release %s: needs to be owner/repo@tag/asset-glob", u.Host+u.Path)
+	}
+	src.repo, src.tag, src.glob = repo, rest[:i], rest[i+1:]
+	if src.owner == "" || src.repo == "" || src.tag == "" || src.glob == "" {
+		return nil, fmt.Errorf("invalid release %s: needs to be owner/repo@tag/asset-glob", u.Host+u.Path)
+	}
+	if _, err := path.Match(src.glob, ""); err != nil {
+		return nil, fmt.Errorf("invalid asset glob %s: %w", src.glob, err)
+	}
+
+	values, err := url.ParseQuery(u.EscapedFragment())
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range values {
+		if len(v) != 1 {
+			return nil, fmt.Errorf("invalid fragment %s: only one value is supported", k)
+		}
+		switch k {
+		case "token":
+			src.token = v[0]
+		case "api":
+			src.api = strings.TrimSuffix(v[0], "/")
+		default:
+			return nil, fmt.Errorf("invalid fragment %s: only token and api are supported", k)
+		}
+	}
+	return src, nil
+}
+
+func (d *githubReleaseDownloader) Download(dest, source string) error {
+	src, err := parseGitHubReleaseSource(source)
+	if err != nil {
+		return err
+	}
+	release, err := d.release(src)
+	if err != nil {
+		return err
+	}
+
+	var matches []string
+	assetURL := ""
+	for _, asset := range release.Assets {
+		if ok, _ := path.Match(src.glob, asset.Name); ok {
+			matches = append(matches, asset.Name)
+			assetURL = asset.URL
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return fmt.Errorf("no asset matching %s in release %s of %s/%s", src.glob, release.TagName, src.owner, src.repo)
+	case 1:
+	default:
+		return fmt.Errorf("multiple assets matching %s in release %s of %s/%s: %s", src.glob, release.TagName, src.owner, src.repo, strings.Join(matches, ", "))
+	}
+
+	// The api redirects to a presigned url of the storage backend which
+	// rejects requests with an additional Authorization header.
+	client := *d.HTTPClient
+	check := d.HTTPClient.CheckRedirect
+	if check == nil {
+		check = DefaultRedirectPolicy.check
+	}
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		req.Header.Del("Authorization")
+		return check(req, via)
+	}
+	resp, err := d.get(&client, assetURL, "application/octet-stream", src)
+	if err != nil {
+		return err
+	}
+	if err := saveResponse(dest, resp); err != nil {
+		return fmt.Errorf("couldn't download asset %s: %w", matches[0], err)
+	}
+	return nil
+}
+
+// release returns the release for the tag, resolving latest to the latest
+// release.
+func (d *githubReleaseDownloader) release(src *githubReleaseSource) (*githubRelease, error) {
+	u := src.api + "/repos/" + url.PathEscape(src.owner) + "/" + url.PathEscape(src.repo) + "/releases/"
+	if src.tag == "latest" {
+		u += "latest"
+	} else {
+		u += "tags/" + url.PathEscape(src.tag)
+	}
+	resp, err := d.get(d.HTTPClient, u, "application/vnd.github+json", src)
+	if err != nil {
+		return nil, err
+	}
+	var release githubRelease
+	if err := decodeJSONResponse(resp, &release); err != nil {
+		return nil, fmt.Errorf("couldn't get release %s of %s/%s: %w", src.tag, src.owner, src.repo, err)
+	}
+	return &release, nil
+}
+
+func (d *githubReleaseDownloader) get(client *http.Client, u, accept string, src *githubReleaseSource) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if src.token != "" {
+		req.Header.Set("Authorization", "Bearer "+src.token)
+	}
+	return client.Do(req)
+}
@@ -0,0 +1,161 @@
+package initializer
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeGitHub serves releases like the GitHub api, redirecting asset downloads
+// to storage.
+func fakeGitHub(t *testing.T, token string, assets map[string][]byte) *httptest.Server {
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			http.Error(w, "only one auth mechanism allowed", http.StatusBadRequest)
+			return
+		}
+		w.Write(assets[r.URL.Path[1:]])
+	}))
+	t.Cleanup(storage.Close)
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			http.Error(w, `{"message": "Bad credentials"}`, http.StatusUnauthorized)
+			return
+		}
+		release := func(tag string) {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"tag_name": %q, "assets": [`, tag)
+			var names []string
+			for name := range assets {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for i, name := range names {
+				if i > 0 {
+					fmt.Fprint(w, ",")
+				}
+				fmt.Fprintf(w, `{"name": %q, "url": "%s/repos/diambra/agents/releases/assets/%s"}`, name, server.URL, name)
+			}
+			fmt.Fprint(w, "]}")
+		}
+		switch path := r.URL.Path; {
+		case path == "/repos/diambra/agents/releases/latest":
+			release("v2")
+		case path == "/repos/diambra/agents/releases/tags/v1":
+			release("v1")
+		case filepath.Dir(path) == "/repos/diambra/agents/releases/assets":
+			if r.Header.Get("Accept") != "application/octet-stream" {
+				release("v1")
+				return
+			}
+			http.Redirect(w, r, storage.URL+"/"+filepath.Base(path), http.StatusFound)
+		default:
+			http.Error(w, `{"message": "Not Found"}`, http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGitHubReleaseDownloader(t *testing.T) {
+	server := fakeGitHub(t, "ghp_token", map[string][]byte{
+		"agent-linux-amd64.tar.gz": []byte("linux"),
+		"agent-darwin-arm64.zip":   []byte("darwin"),
+		"checksums.txt":            []byte("sums"),
+	})
+	fragment := "#token=ghp_token&api=" + url.QueryEscape(server.URL)
+
+	for _, tc := range []struct {
+		name        string
+		source      string
+		expected    string
+		expectedErr string
+	}{
+		{
+			name:     "tag",
+			source:   "github-release://diambra/agents@v1/agent-linux-*" + fragment,
+			expected: "linux",
+		},
+		{
+			name:     "latest",
+			source:   "github-release://diambra/agents@latest/checksums.txt" + fragment,
+			expected: "sums",
+		},
+		{
+			name:        "no match",
+			source:      "github-release://diambra/agents@v1/agent-windows-*" + fragment,
+			expectedErr: "no asset matching agent-windows-* in release v1 of diambra/agents",
+		},
+		{
+			name:        "ambiguous",
+			source:      "github-release://diambra/agents@v1/agent-*" + fragment,
+			expectedErr: "multiple assets matching agent-* in release v1 of diambra/agents: agent-darwin-arm64.zip, agent-linux-amd64.tar.gz",
+		},
+		{
+			name:        "missing release",
+			source:      "github-release://diambra/agents@v3/checksums.txt" + fragment,
+			expectedErr: "couldn't get release v3 of diambra/agents: unexpected status code 404: {\"message\": \"Not Found\"}\n",
+		},
+		{
+			name:        "missing tag",
+			source:      "github-release://diambra/agents/checksums.txt" + fragment,
+			expectedErr: "invalid release diambra/agents/checksums.txt: needs to be owner/repo@tag/asset-glob",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dest := filepath.Join(t.TempDir(), "asset")
+			err := (&githubReleaseDownloader{HTTPClient: server.Client()}).Download(dest, tc.source)
+			if tc.expectedErr != "" {
+				if err == nil || err.Error() != tc.expectedErr {
+					t.Fatalf("expected error %q, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, err := os.ReadFile(dest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.expected, string(content)); diff != "" {
+				t.Errorf("content mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestInitializerGitHubReleaseWithProcessor(t *testing.T) {
+	zip, err := os.ReadFile("testdata/test.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := fakeGitHub(t, "ghp_token", map[string][]byte{"test.zip": zip})
+	root := t.TempDir()
+	init, err := NewInitializer(log.NewNopLogger(), map[string]string{
+		"test": "github-release+unzip://diambra/agents@latest/*.zip#token={{ .Secrets.token }}&api=" + url.QueryEscape(server.URL),
+	}, map[string]string{"token": "ghp_token"}, nil, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := init.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(map[string]string{
+		"test/foo":          "hello world\n",
+		"test/bar":          "something else\n",
+		"test/baz/bux/date": "Sa 29. Apr 13:22:23 CEST 2023\n",
+	}, readTree(t, root)); diff != "" {
+		t.Errorf("files mismatch (-want +got):\n%s", diff)
+	}
+}
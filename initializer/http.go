@@ -17,11 +17,17 @@
 package initializer
 
 import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 type Downloader interface {
@@ -31,14 +37,154 @@ type Downloader interface {
 type httpDownloader struct {
 	HTTPClient *http.Client
 	root       string
+	// filename is the filename from the Content-Disposition header of the
+	// last successful download, which went to filenamePath.
+	filename     string
+	filenamePath string
+}
+
+// Filename returns the filename the server sent in the Content-Disposition
+// header, if the last download went to path and succeeded.
+func (d *httpDownloader) Filename(path string) string {
+	if path != d.filenamePath {
+		return ""
+	}
+	return d.filename
 }
 
 func (d *httpDownloader) Download(path, source string) error {
 	path = filepath.Join(d.root, path)
-	resp, err := d.HTTPClient.Get(source)
+	d.filename, d.filenamePath = "", ""
+	req, maxSize, err := newHTTPRequest(source)
+	if err != nil {
+		return err
+	}
+	client := d.HTTPClient
+	if len(req.Header) > 0 {
+		// Like the Authorization header, per source headers are only sent
+		// to the host of the source.
+		c := *d.HTTPClient
+		check := d.HTTPClient.CheckRedirect
+		if check == nil {
+			check = DefaultRedirectPolicy.check
+		}
+		c.CheckRedirect = func(r *http.Request, via []*http.Request) error {
+			if r.URL.Host != via[0].URL.Host {
+				for name := range req.Header {
+					r.Header.Del(name)
+				}
+			}
+			return check(r, via)
+		}
+		client = &c
+	}
+	resp, err := client.Do(req)
 	if err != nil {
 		return err
 	}
+	if maxSize > 0 {
+		if err := limitResponse(resp, maxSize); err != nil {
+			return err
+		}
+	}
+	if err := saveResponse(path, resp); err != nil {
+		return err
+	}
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		d.filename, d.filenamePath = filepath.Base(params["filename"]), path
+	}
+	return nil
+}
+
+// newHTTPRequest returns a GET request for source with the headers given in
+// its fragment like header=PRIVATE-TOKEN:%20abc and the max_size of the
+// response. Other fragments are ignored.
+func newHTTPRequest(source string) (*http.Request, int64, error) {
+	u, err := url.Parse(source)
+	if err != nil {
+		return nil, 0, err
+	}
+	values, _ := url.ParseQuery(u.EscapedFragment())
+	u.Fragment, u.RawFragment = "", ""
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, header := range values["header"] {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, 0, errors.New("invalid fragment header: needs to be name:value")
+		}
+		req.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	var maxSize int64
+	if v, ok := values["max_size"]; ok {
+		if len(v) != 1 {
+			return nil, 0, fmt.Errorf("invalid fragment max_size: only one value is supported")
+		}
+		if maxSize, err = ParseSize(v[0]); err != nil {
+			return nil, 0, err
+		}
+	}
+	return req, maxSize, nil
+}
+
+// hostHeaderTransport adds default headers to requests by host. Headers
+// already set on the request take precedence.
+type hostHeaderTransport struct {
+	transport http.RoundTripper
+	headers   map[string]http.Header
+}
+
+func (t *hostHeaderTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	headers, ok := t.headers[req.URL.Host]
+	if !ok {
+		headers, ok = t.headers[req.URL.Hostname()]
+	}
+	if !ok {
+		return t.transport.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	for name, values := range headers {
+		if _, ok := req.Header[name]; !ok {
+			req.Header[name] = values
+		}
+	}
+	return t.transport.RoundTrip(req)
+}
+
+// saveResponse writes the body of a successful response to path.
+func saveResponse(path string, resp *http.Response) error {
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", filepath.Dir(path), err)
+	}
+	if resp.ContentLength > 0 {
+		if free, ok := freeSpace(filepath.Dir(path)); ok && uint64(resp.ContentLength) > free {
+			return fmt.Errorf("not enough space for %s: need %d bytes, %d bytes available", path, resp.ContentLength, free)
+		}
+	}
+	fh, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create file %s: %w", path, err)
+	}
+	defer fh.Close()
+	n, err := io.Copy(fh, resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.ContentLength >= 0 && n < resp.ContentLength {
+		return fmt.Errorf("truncated download of %s: got %d of %d bytes", path, n, resp.ContentLength)
+	}
+	return nil
+}
+
+// checkStatus returns an error including the response body if the response
+// isn't successful.
+func checkStatus(resp *http.Response) error {
 	if resp.StatusCode > 299 || resp.StatusCode < 200 {
 		errBody, err := io.ReadAll(resp.Body)
 		if err != nil {
@@ -46,14 +192,21 @@ func (d *httpDownloader) Download(path, source string) error {
 		}
 		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, errBody)
 	}
+	return nil
+}
+
+func decodeXMLResponse(resp *http.Response, v interface{}) error {
 	defer resp.Body.Close()
-	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
-		return fmt.Errorf("failed to create directory %s: %w", filepath.Dir(path), err)
+	if err := checkStatus(resp); err != nil {
+		return err
 	}
-	fh, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0755)
-	if err != nil {
-		return fmt.Errorf("failed to create file %s: %w", path, err)
+	return xml.NewDecoder(resp.Body).Decode(v)
+}
+
+func decodeJSONResponse(resp *http.Response, v interface{}) error {
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return err
 	}
-	_, err = io.Copy(fh, resp.Body)
-	return err
+	return json.NewDecoder(resp.Body).Decode(v)
 }
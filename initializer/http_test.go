@@ -0,0 +1,107 @@
+package initializer
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHTTPHeaders(t *testing.T) {
+	// other is reached by a different host name, so headers scoped to the
+	// api must not be sent to it.
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "token=%q auth=%q", r.Header.Get("PRIVATE-TOKEN"), r.Header.Get("Authorization"))
+	}))
+	defer other.Close()
+	otherURL := strings.Replace(other.URL, "127.0.0.1", "localhost", 1)
+
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, otherURL, http.StatusFound)
+			return
+		}
+		fmt.Fprintf(w, "token=%q auth=%q", r.Header.Get("PRIVATE-TOKEN"), r.Header.Get("Authorization"))
+	}))
+	defer api.Close()
+	apiHost := strings.TrimPrefix(api.URL, "http://")
+
+	for _, tc := range []struct {
+		name        string
+		source      string
+		hostHeaders map[string]map[string]string
+		expected    string
+		expectedErr string
+	}{
+		{
+			name:     "source headers",
+			source:   api.URL + "/file#header=PRIVATE-TOKEN:%20{{ urlquery .Secrets.token }}&header=Authorization:Bearer%20abc",
+			expected: `token="secret" auth="Bearer abc"`,
+		},
+		{
+			name:     "source headers are dropped on redirects to other hosts",
+			source:   api.URL + "/redirect#header=PRIVATE-TOKEN:%20{{ urlquery .Secrets.token }}",
+			expected: `token="" auth=""`,
+		},
+		{
+			name:        "host headers",
+			source:      api.URL + "/file",
+			hostHeaders: map[string]map[string]string{apiHost: {"PRIVATE-TOKEN": "{{ .Secrets.token }}"}},
+			expected:    `token="secret" auth=""`,
+		},
+		{
+			name:        "source headers take precedence",
+			source:      api.URL + "/file#header=PRIVATE-TOKEN:other",
+			hostHeaders: map[string]map[string]string{"127.0.0.1": {"PRIVATE-TOKEN": "{{ .Secrets.token }}"}},
+			expected:    `token="other" auth=""`,
+		},
+		{
+			name:        "host headers are scoped to the host",
+			source:      api.URL + "/redirect",
+			hostHeaders: map[string]map[string]string{apiHost: {"PRIVATE-TOKEN": "{{ .Secrets.token }}"}},
+			expected:    `token="" auth=""`,
+		},
+		{
+			name:        "invalid header",
+			source:      api.URL + "/file#header=" + url.QueryEscape("{{ .Secrets.token }}"),
+			expectedErr: "invalid fragment header: needs to be name:value",
+		},
+		{
+			name:        "missing secret in host headers",
+			source:      api.URL + "/file",
+			hostHeaders: map[string]map[string]string{apiHost: {"PRIVATE-TOKEN": "{{ .Secrets.missing }}"}},
+			expectedErr: `invalid header PRIVATE-TOKEN for host ` + apiHost + `: template: manifest:1:11: executing "manifest" at <.Secrets.missing>: map has no entry for key "missing"`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			init, err := NewInitializer(log.NewNopLogger(), map[string]string{"file": tc.source}, map[string]string{"token": "secret"}, nil, root, WithHostHeaders(tc.hostHeaders))
+			if err == nil {
+				err = init.Init()
+			}
+			if tc.expectedErr != "" {
+				if err == nil || err.Error() != tc.expectedErr {
+					t.Fatalf("expected error %q, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, err := os.ReadFile(filepath.Join(root, "file"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.expected, string(content)); diff != "" {
+				t.Errorf("response mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package initializer
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const hfDefaultEndpoint = "https://huggingface.co"
+
+// hfDownloader downloads repositories from the Hugging Face Hub. Sources have
+// the form hf://org/model[@revision]. The fragment configures the token, the
+// endpoint, the repo_type (model, dataset or space) and include and exclude
+// globs which may be given multiple times.
+type hfDownloader struct {
+	HTTPClient *http.Client
+}
+
+type hfSource struct {
+	repo     string
+	revision string
+	repoType string
+	endpoint string
+	token    string
+	include  []string
+	exclude  []string
+}
+
+func parseHFSource(source string) (*hfSource, error) {
+	// Repositories without org like hf://gpt2@main would be parsed as
+	// userinfo, so only the fragment is taken from the parsed url.
+	u, err := url.Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	repo, _, _ := strings.Cut(strings.TrimPrefix(source, u.Scheme+"://"), "#")
+	src := &hfSource{
+		revision: "main",
+		repoType: "model",
+		endpoint: hfDefaultEndpoint,
+	}
+	if i := strings.LastIndex(repo, "@"); i >= 0 {
+		repo, src.revision = repo[:i], repo[i+1:]
+	}
+	src.repo = strings.Trim(repo, "/")
+	if src.repo == "" || src.revision == "" {
+		return nil, fmt.Errorf("invalid repository %s: needs to be org/name[@revision]", repo)
+	}
+
+	values, err := url.ParseQuery(u.EscapedFragment())
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range values {
+		switch k {
+		case "include":
+			src.include = v
+			continue
+		case "exclude":
+			src.exclude = v
+			continue
+		}
+		if len(v) != 1 {
+			return nil, fmt.Errorf("invalid fragment %s: only one value is supported", k)
+		}
+		switch k {
+		case "token":
+			src.token = v[0]
+		case "endpoint":
+			src.endpoint = strings.TrimSuffix(v[0], "/")
+		case "repo_type":
+			switch v[0] {
+			case "model", "dataset", "space":
+				src.repoType = v[0]
+			default:
+				return nil, fmt.Errorf("invalid repo_type %s: only model, dataset and space are supported", v[0])
+			}
+		default:
+			return nil, fmt.Errorf("invalid fragment %s: only token, endpoint, repo_type, include and exclude are supported", k)
+		}
+	}
+	for _, pattern := range append(src.include, src.exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %s: %w", pattern, err)
+		}
+	}
+	return src, nil
+}
+
+// selected returns true if name matches any include pattern, or no include
+// patterns are given, and no exclude pattern. Patterns without a slash also
+// match the base name.
+func (s *hfSource) selected(name string) bool {
+	match := func(patterns []string) bool {
+		for _, p := range patterns {
+			if ok, _ := path.Match(p, name); ok {
+				return true
+			}
+			if !strings.Contains(p, "/") {
+				if ok, _ := path.Match(p, path.Base(name)); ok {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return (len(s.include) == 0 || match(s.include)) && !match(s.exclude)
+}
+
+// urlPrefix returns the prefix for urls to the repository. Models have no
+// prefix, datasets and spaces are prefixed by their type.
+func (s *hfSource) urlPrefix() string {
+	if s.repoType == "model" {
+		return "/" + s.repo
+	}
+	return "/" + s.repoType + "s/" + s.repo
+}
+
+func (d *hfDownloader) Download(dest, source string) error {
+	src, err := parseHFSource(source)
+	if err != nil {
+		return err
+	}
+
+	files, err := d.files(src)
+	if err != nil {
+		return err
+	}
+	for _, name := range files {
+		if !src.selected(name) {
+			continue
+		}
+		if !filepath.IsLocal(filepath.FromSlash(name)) {
+			return fmt.Errorf("invalid file %s in repository %s: needs to be a relative path", name, src.repo)
+		}
+		u := src.endpoint + src.urlPrefix() + "/resolve/" + url.PathEscape(src.revision) + "/" + (&url.URL{Path: name}).EscapedPath()
+		resp, err := d.get(u, src)
+		if err != nil {
+			return err
+		}
+		if err := saveResponse(filepath.Join(dest, filepath.FromSlash(name)), resp); err != nil {
+			return fmt.Errorf("couldn't download %s from %s@%s: %w", name, src.repo, src.revision, err)
+		}
+	}
+	return nil
+}
+
+// files returns the names of all files in the repository at the revision.
+func (d *hfDownloader) files(src *hfSource) ([]string, error) {
+	u := src.endpoint + "/api/" + src.repoType + "s/" + src.repo + "/revision/" + url.PathEscape(src.revision)
+	resp, err := d.get(u, src)
+	if err != nil {
+		return nil, err
+	}
+	var info struct {
+		Siblings []struct {
+			RFilename string `json:"rfilename"`
+		} `json:"siblings"`
+	}
+	if err := decodeJSONResponse(resp, &info); err != nil {
+		return nil, fmt.Errorf("couldn't get files of %s@%s: %w", src.repo, src.revision, err)
+	}
+	files := make([]string, 0, len(info.Siblings))
+	for _, s := range info.Siblings {
+		files = append(files, s.RFilename)
+	}
+	return files, nil
+}
+
+func (d *hfDownloader) get(u string, src *hfSource) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if src.token != "" {
+		req.Header.Set("Authorization", "Bearer "+src.token)
+	}
+	return d.HTTPClient.Do(req)
+}
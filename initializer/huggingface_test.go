@@ -0,0 +1,115 @@
+package initializer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// fakeHub serves a model repository like the Hugging Face Hub, requiring
+// token for access.
+func fakeHub(repo, revision, token string, files map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.URL.Path == "/api/models/"+repo+"/revision/"+revision:
+			var info struct {
+				Siblings []map[string]string `json:"siblings"`
+			}
+			for name := range files {
+				info.Siblings = append(info.Siblings, map[string]string{"rfilename": name})
+			}
+			json.NewEncoder(w).Encode(info)
+		case strings.HasPrefix(r.URL.Path, "/"+repo+"/resolve/"+revision+"/"):
+			content, ok := files[strings.TrimPrefix(r.URL.Path, "/"+repo+"/resolve/"+revision+"/")]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			fmt.Fprint(w, content)
+		default:
+			http.Error(w, "Repository not found", http.StatusNotFound)
+		}
+	}))
+}
+
+func TestHFDownloader(t *testing.T) {
+	server := fakeHub("diambra/agent", "v1", "hf_token", map[string]string{
+		"config.json":                   "{}",
+		"model.safetensors":             "weights",
+		"pytorch_model.bin":             "pickle",
+		"checkpoints/step1.safetensors": "step1",
+		"README.md":                     "readme",
+	})
+	defer server.Close()
+
+	fragment := "#token=hf_token&endpoint=" + url.QueryEscape(server.URL)
+	for _, tc := range []struct {
+		name        string
+		source      string
+		expected    map[string]string
+		expectedErr string
+	}{
+		{
+			name:   "all",
+			source: "hf://diambra/agent@v1" + fragment,
+			expected: map[string]string{
+				"config.json":                   "{}",
+				"model.safetensors":             "weights",
+				"pytorch_model.bin":             "pickle",
+				"checkpoints/step1.safetensors": "step1",
+				"README.md":                     "readme",
+			},
+		},
+		{
+			name:   "include and exclude",
+			source: "hf://diambra/agent@v1" + fragment + "&include=*.safetensors&include=config.json&exclude=checkpoints/*",
+			expected: map[string]string{
+				"config.json":       "{}",
+				"model.safetensors": "weights",
+			},
+		},
+		{
+			name:        "missing revision",
+			source:      "hf://diambra/agent" + fragment,
+			expectedErr: "couldn't get files of diambra/agent@main: unexpected status code 404: Repository not found\n",
+		},
+		{
+			name:        "missing token",
+			source:      "hf://diambra/agent@v1#endpoint=" + url.QueryEscape(server.URL),
+			expectedErr: "couldn't get files of diambra/agent@v1: unexpected status code 401: Invalid credentials\n",
+		},
+		{
+			name:        "invalid pattern",
+			source:      "hf://diambra/agent@v1#include=[",
+			expectedErr: "invalid pattern [: syntax error in pattern",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "dest")
+			err := (&hfDownloader{HTTPClient: server.Client()}).Download(path, tc.source)
+			if tc.expectedErr != "" {
+				if err == nil || err.Error() != tc.expectedErr {
+					t.Fatalf("expected error %q, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.expected, readTree(t, path)); diff != "" {
+				t.Errorf("files mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -19,7 +19,9 @@ package initializer
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
 	"path/filepath"
@@ -44,6 +46,12 @@ func (s *Sources) Copy() Sources {
 
 // FIXME: Merge with the one in init
 func (s *Sources) Validate() error {
+	return s.ValidateWithPolicy(nil)
+}
+
+// ValidateWithPolicy validates the sources like Validate and checks their
+// hosts against the policy.
+func (s *Sources) ValidateWithPolicy(policy *HostPolicy) error {
 	for path, us := range *s {
 		if !filepath.IsLocal(path) {
 			return fmt.Errorf("invalid path %s: needs to be an relative path", path)
@@ -51,73 +59,279 @@ func (s *Sources) Validate() error {
 		if us == "" {
 			return fmt.Errorf("url for path %s is empty", path)
 		}
-		u, processor, redactedURL, err := parseAndRedact(us)
+		src, err := parseSource(us)
+		var urlErr *invalidURLError
+		if errors.As(err, &urlErr) {
+			return fmt.Errorf("invalid url %s for path %s: %w", urlErr.redactedURL, path, urlErr.err)
+		}
 		if err != nil {
-			return fmt.Errorf("invalid url %s for path %s: %w", redactedURL, path, err)
-		}
-		switch u.Scheme {
-		case "http", "https":
-			switch processor {
-			case "", "zip", "unzip":
-				// ok
-			default:
-				return fmt.Errorf("invalid processor %s for path %s: only zip and unzip are supported", processor, path)
+			return fmt.Errorf("invalid url for path %s: %w", path, err)
+		}
+		if dest := decompressedPath(path, src.mirrors[0].processor); dest != path {
+			if _, ok := (*s)[dest]; ok {
+				return fmt.Errorf("invalid path %s: decompressing it overwrites path %s", path, dest)
+			}
+		}
+		for _, m := range src.mirrors {
+			if err := m.validate(path); err != nil {
+				return err
+			}
+			if policy == nil {
+				continue
 			}
-		case "git":
-			switch processor {
-			case "https", "http":
-				// ok
-			default:
-				return fmt.Errorf("invalid processor %s for path %s: only http(s) are supported", processor, path)
+			if err := policy.checkMirror(m); err != nil {
+				return fmt.Errorf("invalid url %s for path %s: %w", m.redactedURL, path, err)
 			}
+		}
+		if policy == nil || src.signature == nil {
+			continue
+		}
+		if u, err := url.Parse(src.signature.sig); err == nil && (u.Scheme == "https" || u.Scheme == "http") {
+			if err := policy.check(u.Scheme, u); err != nil {
+				return fmt.Errorf("invalid signature url for path %s: %w", path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validate checks the scheme and processor of the mirror url for path.
+func (m mirror) validate(path string) error {
+	scheme := m.url.Scheme
+	if scheme == "" && filepath.IsAbs(m.url.Path) {
+		// Absolute paths are local files.
+		scheme = "file"
+	}
+	if scheme != "http" && scheme != "https" && scheme != "data" && scheme != "literal" {
+		if values, _ := url.ParseQuery(m.url.EscapedFragment()); values.Has("max_size") {
+			return fmt.Errorf("invalid url %s for path %s: max_size is only supported for http(s)", m.redactedURL, path)
+		}
+	}
+	switch scheme {
+	case "http", "https", "s3", "gs", "az", "sftp", "github-release", "file", "data":
+		switch m.processor {
+		case "", "zip", "unzip", "auto", "gunzip", "unzstd", "unxz", "bunzip2":
+			// ok
 		default:
-			return fmt.Errorf("invalid url %s for path %s: only http(s) and git+http(s) are supported", redactedURL, path)
+			return fmt.Errorf("invalid processor %s for path %s: only zip, unzip, auto, gunzip, unzstd, unxz and bunzip2 are supported", m.processor, path)
 		}
+	case "oci", "docker-image", "hf", "literal":
+		if m.processor != "" {
+			return fmt.Errorf("invalid processor %s for path %s: %s doesn't support processors", m.processor, path, m.url.Scheme)
+		}
+	case "git":
+		switch m.processor {
+		case "https", "http", "ssh":
+			// ok
+		default:
+			return fmt.Errorf("invalid processor %s for path %s: only http(s) and ssh are supported", m.processor, path)
+		}
+	default:
+		return fmt.Errorf("invalid url %s for path %s: only http(s), s3, gs, az, sftp, github-release, oci, docker-image, hf, file, data, literal, git+http(s) and git+ssh are supported", m.redactedURL, path)
 	}
 	return nil
 }
 
 type Initializer struct {
-	logger         log.Logger
-	HTTPDownloader Downloader
-	GitDownloader  Downloader
-	ZipProcessor   Processor
-	sources        Sources
-	secrets        map[string]string
-	assets         Sources
-	root           string
+	logger            log.Logger
+	HTTPDownloader    Downloader
+	GitDownloader     Downloader
+	S3Downloader      Downloader
+	GCSDownloader     Downloader
+	AzureDownloader   Downloader
+	OCIDownloader     Downloader
+	ImageDownloader   Downloader
+	HFDownloader      Downloader
+	SFTPDownloader    Downloader
+	ReleaseDownloader Downloader
+	FileDownloader    Downloader
+	DataDownloader    Downloader
+	ZipProcessor      Processor
+	sources           Sources
+	secrets           map[string]string
+	assets            Sources
+	root              string
+	nativeGit         bool
+	filePrefixes      []string
+	hostHeaders       map[string]map[string]string
+	tlsConfigs        map[string]TLSConfig
+	proxy             *ProxyConfig
+	maxSize           int64
+	policy            *addressPolicy
+	hostPolicy        *HostPolicy
+	redirects         RedirectPolicy
+	client            *http.Client
+	redactor          *redactor
+}
+
+// Option configures an Initializer.
+type Option func(*Initializer)
+
+// WithNativeGit makes the Initializer clone repositories using a pure Go git
+// implementation instead of the git binary.
+func WithNativeGit() Option {
+	return func(i *Initializer) {
+		i.nativeGit = true
+	}
+}
+
+// WithFilePrefixes allows file sources below the given directories. Without
+// it file sources are rejected.
+func WithFilePrefixes(prefixes ...string) Option {
+	return func(i *Initializer) {
+		i.filePrefixes = append(i.filePrefixes, prefixes...)
+	}
+}
+
+// WithHostHeaders sets default headers for http requests by host, like
+// {"gitlab.example.com": {"PRIVATE-TOKEN": "{{ .Secrets.gitlab_token }}"}}.
+// The values are templated with the secrets.
+func WithHostHeaders(headers map[string]map[string]string) Option {
+	return func(i *Initializer) {
+		if i.hostHeaders == nil {
+			i.hostHeaders = make(map[string]map[string]string)
+		}
+		for host, h := range headers {
+			if i.hostHeaders[host] == nil {
+				i.hostHeaders[host] = make(map[string]string)
+			}
+			for name, value := range h {
+				i.hostHeaders[host][name] = value
+			}
+		}
+	}
+}
+
+// WithTLSConfig configures TLS by host for http based sources and git over
+// https. The config for "*" applies to hosts without their own config.
+func WithTLSConfig(configs map[string]TLSConfig) Option {
+	return func(i *Initializer) {
+		if i.tlsConfigs == nil {
+			i.tlsConfigs = make(map[string]TLSConfig)
+		}
+		for host, c := range configs {
+			i.tlsConfigs[host] = c
+		}
+	}
+}
+
+// WithProxy makes http based sources and git use the proxy config instead
+// of the environment.
+func WithProxy(proxy ProxyConfig) Option {
+	return func(i *Initializer) {
+		i.proxy = &proxy
+	}
+}
+
+// WithMaxSize limits the size of all http responses to max bytes.
+func WithMaxSize(max int64) Option {
+	return func(i *Initializer) {
+		i.maxSize = max
+	}
+}
+
+// WithSSRFProtection blocks connections to loopback, private, link-local and
+// metadata addresses, except for the allowed networks.
+func WithSSRFProtection(allowed []*net.IPNet) Option {
+	return func(i *Initializer) {
+		i.policy = &addressPolicy{allowed: allowed}
+	}
+}
+
+// WithHostPolicy restricts the hosts of sources, redirects and git
+// submodules to the ones allowed by the policy.
+func WithHostPolicy(policy *HostPolicy) Option {
+	return func(i *Initializer) {
+		i.hostPolicy = policy
+	}
+}
+
+// WithRedirectPolicy configures how http redirects are followed instead of
+// DefaultRedirectPolicy.
+func WithRedirectPolicy(policy RedirectPolicy) Option {
+	return func(i *Initializer) {
+		i.redirects = policy
+	}
 }
 
 type TemplateData struct {
 	Secrets *map[string]string
 }
 
-func NewInitializer(logger log.Logger, sources Sources, secrets, assets map[string]string, root string) (*Initializer, error) {
+func NewInitializer(logger log.Logger, sources Sources, secrets, assets map[string]string, root string, options ...Option) (*Initializer, error) {
+	r := newRedactor(secrets)
+	logger = newRedactingLogger(logger, r)
 	init := &Initializer{
-		logger:  logger,
-		root:    root,
-		sources: sources.Copy(),
-		secrets: secrets,
-		assets:  assets,
-		HTTPDownloader: &httpDownloader{
-			HTTPClient: http.DefaultClient,
-		},
-		GitDownloader: NewGitDownloader(logger),
-		ZipProcessor:  &ZipProcessor{},
+		logger:         logger,
+		redactor:       r,
+		root:           root,
+		sources:        sources.Copy(),
+		secrets:        secrets,
+		assets:         assets,
+		DataDownloader: &dataDownloader{},
+		ZipProcessor:   &ZipProcessor{},
+		redirects:      DefaultRedirectPolicy,
+	}
+	for _, option := range options {
+		option(init)
+	}
+	for host, c := range init.tlsConfigs {
+		rendered, err := c.render(secrets)
+		if err != nil {
+			return nil, r.redactError(fmt.Errorf("invalid tls config for %s: %w", host, err))
+		}
+		init.tlsConfigs[host] = rendered
+	}
+	client, err := init.httpClient()
+	if err != nil {
+		return nil, r.redactError(err)
+	}
+	init.client = client
+	init.HTTPDownloader = &httpDownloader{
+		HTTPClient: client,
 	}
+	init.S3Downloader = newS3Downloader(client)
+	init.GCSDownloader = newGCSDownloader(client)
+	init.AzureDownloader = &azureDownloader{
+		HTTPClient: client,
+	}
+	init.OCIDownloader = &ociDownloader{
+		HTTPClient: client,
+	}
+	init.ImageDownloader = &dockerImageDownloader{
+		HTTPClient: client,
+	}
+	init.HFDownloader = &hfDownloader{
+		HTTPClient: client,
+	}
+	init.ReleaseDownloader = &githubReleaseDownloader{
+		HTTPClient: client,
+	}
+	init.SFTPDownloader = &sftpDownloader{}
+	if init.policy != nil {
+		init.SFTPDownloader = &sftpDownloader{dial: init.policy.dialer().Dial}
+	}
+	if init.nativeGit {
+		git := NewNativeGitDownloader(logger, secrets).(*nativeGitDownloader)
+		git.httpClient = client
+		git.policy = init.policy
+		git.hostPolicy = init.hostPolicy
+		init.GitDownloader = git
+	} else {
+		git := NewGitDownloader(logger, secrets).(*gitDownloader)
+		git.tls = init.tlsConfigs
+		git.proxy = init.proxy
+		git.policy = init.policy
+		git.hostPolicy = init.hostPolicy
+		git.noRedirects = init.hostPolicy != nil || init.redirects != DefaultRedirectPolicy
+		init.GitDownloader = git
+	}
+	init.FileDownloader = &fileDownloader{allowed: init.filePrefixes}
 
 	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
 		DecodeHook: func(from, to reflect.Type, data interface{}) (interface{}, error) {
 			if to.Kind() == reflect.String && from.Kind() == reflect.String {
-				tmpl, err := template.New("manifest").Option("missingkey=error").Parse(data.(string))
-				if err != nil {
-					return "", err
-				}
-				var buf bytes.Buffer
-				if err := tmpl.Execute(&buf, TemplateData{Secrets: &secrets}); err != nil {
-					return "", err
-				}
-				return buf.String(), nil
+				return renderTemplate(data.(string), secrets)
 			}
 			return data, nil
 		},
@@ -130,10 +344,58 @@ func NewInitializer(logger log.Logger, sources Sources, secrets, assets map[stri
 		return nil, fmt.Errorf("failed to parse sources: %w", err)
 	}
 
-	return init, init.sources.Validate()
+	return init, r.redactError(init.Validate())
 }
 
-func NewInitializerFromStrings(logger log.Logger, sourcesStr, secretsStr, assetsStr, root string) (*Initializer, error) {
+// renderTemplate executes s as template with the secrets.
+func renderTemplate(s string, secrets map[string]string) (string, error) {
+	tmpl, err := template.New("manifest").Option("missingkey=error").Parse(s)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, TemplateData{Secrets: &secrets}); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// httpClient returns the client used by all http based downloaders.
+func (i *Initializer) httpClient() (*http.Client, error) {
+	client := &http.Client{CheckRedirect: checkRedirect(i.logger, i.redirects, i.hostPolicy)}
+	if len(i.hostHeaders) == 0 && len(i.tlsConfigs) == 0 && i.proxy == nil && i.maxSize == 0 && i.policy == nil {
+		return client, nil
+	}
+	transport, err := newTransport(i.proxy, i.tlsConfigs, i.policy)
+	if err != nil {
+		return nil, err
+	}
+	if i.maxSize > 0 {
+		transport = &limitTransport{transport: transport, max: i.maxSize}
+	}
+	client.Transport = transport
+	if len(i.hostHeaders) == 0 {
+		return client, nil
+	}
+	headers := make(map[string]http.Header)
+	for host, h := range i.hostHeaders {
+		headers[host] = make(http.Header)
+		for name, value := range h {
+			v, err := renderTemplate(value, i.secrets)
+			if err != nil {
+				return nil, fmt.Errorf("invalid header %s for host %s: %w", name, host, err)
+			}
+			headers[host].Set(name, v)
+		}
+	}
+	client.Transport = &hostHeaderTransport{
+		transport: transport,
+		headers:   headers,
+	}
+	return client, nil
+}
+
+func NewInitializerFromStrings(logger log.Logger, sourcesStr, secretsStr, assetsStr, root string, options ...Option) (*Initializer, error) {
 	var (
 		secrets map[string]string
 		sources map[string]string
@@ -154,54 +416,161 @@ func NewInitializerFromStrings(logger log.Logger, sourcesStr, secretsStr, assets
 		}
 	}
 
-	return NewInitializer(logger, sources, secrets, assets, root)
+	return NewInitializer(logger, sources, secrets, assets, root, options...)
 }
 
 func (i *Initializer) init() error {
 	if err := i.processSources(level.Info(i.logger), i.sources); err != nil {
-		return err
+		return i.redactor.redactError(err)
 	}
 	if err := i.processSources(level.Debug(i.logger), i.assets); err != nil {
-		return err
+		return i.redactor.redactError(err)
 	}
 	return nil
 }
 
 func (i *Initializer) processSources(logger log.Logger, sources Sources) error {
 	for path, source := range sources {
-		u, processor, redactedURL, err := parseAndRedact(source)
+		src, err := parseSource(source)
 		if err != nil {
 			return err
 		}
-		switch u.Scheme {
-		case "http", "https":
-			logger.Log("msg", "downloading", "path", path, "source", redactedURL)
-			if err := i.HTTPDownloader.Download(filepath.Join(i.root, path), u.String()); err != nil {
-				return err
-			}
-		case "git":
-			logger.Log("msg", "cloning", "path", path, "source", redactedURL)
-			u.Scheme = processor
-			if err := i.GitDownloader.Download(filepath.Join(i.root, path), u.String()); err != nil {
-				return err
-			}
+		m, err := i.fetchMirrors(logger, path, src)
+		if err != nil {
+			return err
+		}
+		if err := i.process(logger, path, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-		default:
-			return fmt.Errorf("unsupported scheme %q", u.Scheme)
+// process runs the processor of the mirror on the download at path.
+func (i *Initializer) process(logger log.Logger, path string, m mirror) error {
+	dest := filepath.Join(i.root, path)
+	processors := []string{m.processor}
+	if m.processor == "auto" {
+		name := filepath.Base(m.url.Path)
+		if m.url.Scheme == "http" || m.url.Scheme == "https" {
+			if f, ok := i.HTTPDownloader.(interface{ Filename(string) string }); ok && f.Filename(dest) != "" {
+				name = f.Filename(dest)
+			}
 		}
+		detected, err := detectProcessors(dest, name)
+		if err != nil {
+			return err
+		}
+		if len(detected) == 0 {
+			logger.Log("msg", "no processor detected", "path", path)
+			return nil
+		}
+		processors = detected
+		logger.Log("msg", "detected processor", "path", path, "processor", strings.Join(detected, "+"))
+	}
+	for _, processor := range processors {
 		switch processor {
 		case "zip", "unzip":
 			logger.Log("msg", "processing", "path", path, "processor", processor)
-			if err := i.ZipProcessor.Process(path); err != nil {
+			if err := i.ZipProcessor.Process(dest); err != nil {
 				return err
 			}
+		case "untar":
+			logger.Log("msg", "processing", "path", path, "processor", processor)
+			if err := untarFile(dest); err != nil {
+				return err
+			}
+		case "gunzip", "bunzip2", "unxz", "unzstd":
+			logger.Log("msg", "processing", "path", path, "processor", processor)
+			if m.processor == "auto" {
+				// Detected formats always end up at the path of the source.
+				if err := decompressTo(dest, dest, processor); err != nil {
+					return err
+				}
+				continue
+			}
+			decompressed, err := decompressFile(dest, processor)
+			if err != nil {
+				return err
+			}
+			dest = decompressed
+		}
+	}
+	return nil
+}
+
+// fetch downloads a single url to path using the downloader for its scheme.
+func (i *Initializer) fetch(logger log.Logger, path string, u *url.URL, processor, redactedURL string) error {
+	switch u.Scheme {
+	case "http", "https":
+		logger.Log("msg", "downloading", "path", path, "source", redactedURL)
+		if err := i.HTTPDownloader.Download(filepath.Join(i.root, path), u.String()); err != nil {
+			return err
+		}
+	case "s3":
+		logger.Log("msg", "downloading", "path", path, "source", redactedURL)
+		if err := i.S3Downloader.Download(filepath.Join(i.root, path), u.String()); err != nil {
+			return err
+		}
+	case "gs":
+		logger.Log("msg", "downloading", "path", path, "source", redactedURL)
+		if err := i.GCSDownloader.Download(filepath.Join(i.root, path), u.String()); err != nil {
+			return err
+		}
+	case "az":
+		logger.Log("msg", "downloading", "path", path, "source", redactedURL)
+		if err := i.AzureDownloader.Download(filepath.Join(i.root, path), u.String()); err != nil {
+			return err
+		}
+	case "sftp":
+		logger.Log("msg", "downloading", "path", path, "source", redactedURL)
+		if err := i.SFTPDownloader.Download(filepath.Join(i.root, path), u.String()); err != nil {
+			return err
+		}
+	case "github-release":
+		logger.Log("msg", "downloading", "path", path, "source", redactedURL)
+		if err := i.ReleaseDownloader.Download(filepath.Join(i.root, path), u.String()); err != nil {
+			return err
+		}
+	case "oci":
+		logger.Log("msg", "pulling", "path", path, "source", redactedURL)
+		if err := i.OCIDownloader.Download(filepath.Join(i.root, path), u.String()); err != nil {
+			return err
+		}
+	case "docker-image":
+		logger.Log("msg", "extracting", "path", path, "source", redactedURL)
+		if err := i.ImageDownloader.Download(filepath.Join(i.root, path), u.String()); err != nil {
+			return err
 		}
+	case "hf":
+		logger.Log("msg", "downloading", "path", path, "source", redactedURL)
+		if err := i.HFDownloader.Download(filepath.Join(i.root, path), u.String()); err != nil {
+			return err
+		}
+	case "file", "":
+		logger.Log("msg", "copying", "path", path, "source", redactedURL)
+		if err := i.FileDownloader.Download(filepath.Join(i.root, path), u.String()); err != nil {
+			return err
+		}
+	case "data", "literal":
+		logger.Log("msg", "writing", "path", path, "source", redactedURL)
+		if err := i.DataDownloader.Download(filepath.Join(i.root, path), u.String()); err != nil {
+			return err
+		}
+	case "git":
+		logger.Log("msg", "cloning", "path", path, "source", redactedURL)
+		u.Scheme = processor
+		if err := i.GitDownloader.Download(filepath.Join(i.root, path), u.String()); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
 	}
 	return nil
 }
 
 func (i *Initializer) Validate() error {
-	return i.sources.Validate()
+	return i.sources.ValidateWithPolicy(i.hostPolicy)
 }
 
 func (i *Initializer) Sources() string {
@@ -223,6 +592,11 @@ func (i *Initializer) Secrets() string {
 const redactedPlaceholder = "xxxxx"
 
 func parseAndRedact(s string) (*url.URL, string, string, error) {
+	if strings.HasPrefix(s, literalPrefix) {
+		// Literal content isn't a url and may contain anything, including
+		// newlines.
+		return &url.URL{Scheme: "literal", Opaque: strings.TrimPrefix(s, literalPrefix)}, "", literalPrefix, nil
+	}
 	u, err := url.Parse(s)
 	if err != nil {
 		return nil, "", "", err
@@ -1,6 +1,7 @@
 package initializer
 
 import (
+	"errors"
 	"reflect"
 	"strings"
 	"testing"
@@ -132,6 +133,24 @@ func TestInitializer(t *testing.T) {
 				"level=info msg=downloading path=bar source=http://bar",
 			},
 		},
+		{
+			name: "template secret in path",
+			sources: map[string]string{
+				"foo": "http://foo/{{ .Secrets.token }}/file.zip",
+				"bar": "http://bar",
+			},
+			secrets: map[string]string{
+				"token": "abcd",
+			},
+			expected: map[string]string{
+				"foo": "http://foo/abcd/file.zip",
+				"bar": "http://bar",
+			},
+			expectedLog: []string{
+				"level=info msg=downloading path=foo source=http://foo/xxxxx/file.zip",
+				"level=info msg=downloading path=bar source=http://bar",
+			},
+		},
 		{
 			name: "template without secret",
 			sources: map[string]string{
@@ -266,3 +285,26 @@ func TestNewInitializerFromStrings(t *testing.T) {
 		})
 	}
 }
+
+type failingDownloader struct {
+	err error
+}
+
+func (d *failingDownloader) Download(path, source string) error {
+	return d.err
+}
+
+func TestInitializerRedactsErrors(t *testing.T) {
+	init, err := NewInitializer(log.NewNopLogger(), map[string]string{"foo": "http://foo/{{ .Secrets.token }}"}, map[string]string{"token": "abcd"}, nil, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	init.HTTPDownloader = &failingDownloader{err: errors.New("unexpected status code 403: invalid token abcd")}
+	err = init.Init()
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if diff := cmp.Diff("unexpected status code 403: invalid token xxxxx", err.Error()); diff != "" {
+		t.Errorf("error mismatch (-want +got):\n%s", diff)
+	}
+}
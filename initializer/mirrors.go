@@ -0,0 +1,198 @@
+package initializer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-kit/log"
+)
+
+// source is a parsed source. It consists of one or more whitespace separated
+// mirror urls which are tried in order. Mirrors need a sha256 checksum in the
+// fragment of any of the urls so they are known to serve identical content.
+type source struct {
+	mirrors   []mirror
+	sha256    string
+	signature *signature
+}
+
+type mirror struct {
+	url         *url.URL
+	processor   string
+	redactedURL string
+}
+
+// invalidURLError is returned by parseSource for the mirror url which is
+// invalid.
+type invalidURLError struct {
+	redactedURL string
+	err         error
+}
+
+func (e *invalidURLError) Error() string {
+	return fmt.Sprintf("invalid url %s: %v", e.redactedURL, e.err)
+}
+
+func (e *invalidURLError) Unwrap() error {
+	return e.err
+}
+
+func parseSource(s string) (*source, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return nil, errors.New("url is empty")
+	}
+	if len(fields) == 1 || strings.HasPrefix(s, literalPrefix) || strings.HasPrefix(s, "data:") || !allURLs(fields) {
+		// Single sources are used as is, inline content like literals
+		// and data urls may contain whitespace.
+		fields = []string{s}
+	}
+
+	src := &source{}
+	for _, field := range fields {
+		u, processor, redactedURL, err := parseAndRedact(field)
+		if err != nil {
+			return nil, &invalidURLError{redactedURL, err}
+		}
+		checksum, err := cutChecksum(u)
+		if err != nil {
+			return nil, &invalidURLError{redactedURL, err}
+		}
+		if checksum != "" {
+			if src.sha256 != "" && src.sha256 != checksum {
+				return nil, &invalidURLError{redactedURL, errors.New("sha256 differs from other mirrors")}
+			}
+			src.sha256 = checksum
+		}
+		sig, err := cutSignature(u)
+		if err != nil {
+			return nil, &invalidURLError{redactedURL, err}
+		}
+		if sig != nil {
+			if src.signature != nil && *src.signature != *sig {
+				return nil, &invalidURLError{redactedURL, errors.New("signature differs from other mirrors")}
+			}
+			src.signature = sig
+		}
+		if len(src.mirrors) > 0 && processor != src.mirrors[0].processor {
+			return nil, &invalidURLError{redactedURL, errors.New("all mirrors need the same processor")}
+		}
+		src.mirrors = append(src.mirrors, mirror{url: u, processor: processor, redactedURL: redactedURL})
+	}
+	if len(src.mirrors) > 1 && src.sha256 == "" {
+		return nil, &invalidURLError{src.mirrors[0].redactedURL, errors.New("mirrors need a sha256 in the fragment to verify they serve identical content")}
+	}
+	return src, nil
+}
+
+// allURLs returns true if all fields are urls with a scheme, so they are
+// mirrors and not a single source containing whitespace.
+func allURLs(fields []string) bool {
+	for _, f := range fields {
+		if u, err := url.Parse(f); err != nil || u.Scheme == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// cutChecksum removes the sha256 option from the fragment of u and returns
+// it, so downloaders don't see it.
+func cutChecksum(u *url.URL) (string, error) {
+	checksum, err := cutFragment(u, "sha256")
+	if err != nil || checksum == "" {
+		return "", err
+	}
+	if b, err := hex.DecodeString(checksum); err != nil || len(b) != sha256.Size {
+		return "", fmt.Errorf("invalid sha256 %s: needs to be %d hex encoded bytes", checksum, sha256.Size)
+	}
+	return strings.ToLower(checksum), nil
+}
+
+// cutFragment removes the option name from the fragment of u and returns
+// its value, so downloaders don't see it.
+func cutFragment(u *url.URL, name string) (string, error) {
+	if u.Fragment == "" {
+		return "", nil
+	}
+	values, err := url.ParseQuery(u.EscapedFragment())
+	value, ok := values[name]
+	if !ok {
+		// Fragments of plain http urls don't need to be options.
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(value) != 1 {
+		return "", fmt.Errorf("invalid fragment %s: only one value is supported", name)
+	}
+	delete(values, name)
+	u.RawFragment = values.Encode()
+	u.Fragment, _ = url.PathUnescape(u.RawFragment)
+	return value[0], nil
+}
+
+// fetchMirrors downloads src to path, trying the mirrors in order until one
+// succeeds and matches the checksum and signature. It returns the mirror
+// used.
+func (i *Initializer) fetchMirrors(logger log.Logger, path string, src *source) (mirror, error) {
+	dest := filepath.Join(i.root, path)
+	var err error
+	for n, m := range src.mirrors {
+		if n > 0 {
+			logger.Log("msg", "mirror failed, trying next", "path", path, "source", src.mirrors[n-1].redactedURL, "err", err)
+			if err := os.RemoveAll(dest); err != nil {
+				return mirror{}, fmt.Errorf("couldn't remove partial download %s: %w", dest, err)
+			}
+		}
+		err = i.fetch(logger, path, m.url, m.processor, m.redactedURL)
+		if err == nil && src.sha256 != "" {
+			err = verifySHA256(dest, src.sha256)
+		}
+		if err == nil && src.signature != nil {
+			err = i.verifySignature(dest, src.signature)
+			if err == nil {
+				logger.Log("msg", "verified signature", "path", path, "key", src.signature.key)
+			}
+		}
+		if err == nil {
+			if len(src.mirrors) > 1 {
+				logger.Log("msg", "downloaded from mirror", "path", path, "source", m.redactedURL)
+			}
+			return m, nil
+		}
+	}
+	if len(src.mirrors) > 1 {
+		return mirror{}, fmt.Errorf("all %d mirrors failed, last error: %w", len(src.mirrors), err)
+	}
+	return mirror{}, err
+}
+
+// verifySHA256 returns an error if the file at path doesn't have the
+// expected checksum.
+func verifySHA256(path, expected string) error {
+	fh, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	if fi, err := fh.Stat(); err != nil || !fi.Mode().IsRegular() {
+		return fmt.Errorf("couldn't verify sha256 of %s: not a file", path)
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, fh); err != nil {
+		return fmt.Errorf("couldn't verify sha256 of %s: %w", path, err)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != expected {
+		return fmt.Errorf("sha256 mismatch for %s: expected %s, got %s", path, expected, got)
+	}
+	return nil
+}
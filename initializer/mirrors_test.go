@@ -0,0 +1,136 @@
+package initializer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestInitializerMirrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/down/model.bin":
+			http.Error(w, "maintenance", http.StatusServiceUnavailable)
+		case "/stale/model.bin":
+			fmt.Fprint(w, "old weights")
+		default:
+			fmt.Fprint(w, "weights")
+		}
+	}))
+	defer server.Close()
+	sum := sha256.Sum256([]byte("weights"))
+	checksum := hex.EncodeToString(sum[:])
+
+	for _, tc := range []struct {
+		name        string
+		source      string
+		expectedLog []string
+		expectedErr string
+	}{
+		{
+			name:   "fallback",
+			source: server.URL + "/down/model.bin " + server.URL + "/stale/model.bin\n" + server.URL + "/good/model.bin#sha256=" + checksum,
+			expectedLog: []string{
+				"level=info msg=downloading path=model.bin source=" + server.URL + "/down/model.bin",
+				"level=info msg=\"mirror failed, trying next\" path=model.bin source=" + server.URL + "/down/model.bin err=\"unexpected status code 503: maintenance\\n\"",
+				"level=info msg=downloading path=model.bin source=" + server.URL + "/stale/model.bin",
+				"level=info msg=\"mirror failed, trying next\" path=model.bin source=" + server.URL + "/stale/model.bin err=\"sha256 mismatch for ROOT/model.bin: expected " + checksum + ", got 71aa40389f7b6ebb279bf4ab4dc72636faff31a00c23a5c37b7e6ef1d2eb5498\"",
+				"level=info msg=downloading path=model.bin source=" + server.URL + "/good/model.bin",
+				"level=info msg=\"downloaded from mirror\" path=model.bin source=" + server.URL + "/good/model.bin",
+			},
+		},
+		{
+			name:   "single url with checksum",
+			source: server.URL + "/good/model.bin#sha256=" + checksum,
+			expectedLog: []string{
+				"level=info msg=downloading path=model.bin source=" + server.URL + "/good/model.bin",
+			},
+		},
+		{
+			name:        "all mirrors fail",
+			source:      server.URL + "/down/model.bin " + server.URL + "/down/model.bin#sha256=" + checksum,
+			expectedErr: "all 2 mirrors failed, last error: unexpected status code 503: maintenance\n",
+		},
+		{
+			name:        "mirrors without checksum",
+			source:      server.URL + "/down/model.bin " + server.URL + "/good/model.bin",
+			expectedErr: "invalid url " + server.URL + "/down/model.bin for path model.bin: mirrors need a sha256 in the fragment to verify they serve identical content",
+		},
+		{
+			name:        "mirrors with different processors",
+			source:      server.URL + "/good/model.bin#sha256=" + checksum + " " + strings.Replace(server.URL, "http", "http+zip", 1) + "/good/model.bin",
+			expectedErr: "invalid url " + server.URL + "/good/model.bin for path model.bin: all mirrors need the same processor",
+		},
+		{
+			name:        "invalid checksum",
+			source:      server.URL + "/good/model.bin#sha256=abc",
+			expectedErr: "invalid url " + server.URL + "/good/model.bin for path model.bin: invalid sha256 abc: needs to be 32 hex encoded bytes",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				sw   = &sliceWriter{}
+				root = t.TempDir()
+			)
+			init, err := NewInitializer(log.NewLogfmtLogger(sw), map[string]string{"model.bin": tc.source}, nil, nil, root)
+			if err == nil {
+				err = init.Init()
+			}
+			if tc.expectedErr != "" {
+				if err == nil || err.Error() != tc.expectedErr {
+					t.Fatalf("expected error %q, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, err := os.ReadFile(filepath.Join(root, "model.bin"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != "weights" {
+				t.Errorf("unexpected content %q", content)
+			}
+			for i := range sw.slices {
+				sw.slices[i] = strings.ReplaceAll(sw.slices[i], root, "ROOT")
+			}
+			if diff := cmp.Diff(tc.expectedLog, sw.slices); diff != "" {
+				t.Errorf("log mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseSourceWhitespace(t *testing.T) {
+	checksum := strings.Repeat("0", 64)
+	for source, mirrors := range map[string]int{
+		"data:,hello world":                                      1,
+		"literal:hello world":                                    1,
+		"data:,hello https://example.com/x":                      1,
+		"literal:see https://example.com":                        1,
+		"literal:value\n":                                        1,
+		"https://example.com/x s3://bucket/x#sha256=" + checksum: 2,
+	} {
+		src, err := parseSource(source)
+		if err != nil {
+			t.Errorf("%q: %v", source, err)
+			continue
+		}
+		if len(src.mirrors) != mirrors {
+			t.Errorf("%q: expected %d mirrors, got %d", source, mirrors, len(src.mirrors))
+		}
+		if u := src.mirrors[0].url; u.Scheme == "literal" && "literal:"+u.Opaque != source {
+			t.Errorf("%q: expected literal to be kept as is, got %q", source, u.Opaque)
+		}
+	}
+}
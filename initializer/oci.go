@@ -0,0 +1,380 @@
+package initializer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	ociManifestMediaType    = "application/vnd.oci.image.manifest.v1+json"
+	ociIndexMediaType       = "application/vnd.oci.image.index.v1+json"
+	dockerManifestMediaType = "application/vnd.docker.distribution.manifest.v2+json"
+	dockerListMediaType     = "application/vnd.docker.distribution.manifest.list.v2+json"
+
+	// ociTitleAnnotation holds the file name of a layer pushed by ORAS.
+	ociTitleAnnotation = "org.opencontainers.image.title"
+)
+
+// ociDownloader pulls OCI artifacts like the ones pushed by ORAS. Sources have
+// the form oci://[user:password@]registry/repository:tag or
+// oci://registry/repository@sha256:digest. Layers with a title annotation
+// which are not archives are written to a file with that name, all other
+// layers are extracted to the source path.
+type ociDownloader struct {
+	HTTPClient *http.Client
+}
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *struct {
+		OS           string `json:"os"`
+		Architecture string `json:"architecture"`
+		Variant      string `json:"variant,omitempty"`
+	} `json:"platform,omitempty"`
+}
+
+// ociManifest is an image manifest or an index, depending on the media type.
+type ociManifest struct {
+	MediaType string          `json:"mediaType"`
+	Config    ociDescriptor   `json:"config"`
+	Layers    []ociDescriptor `json:"layers"`
+	Manifests []ociDescriptor `json:"manifests"`
+}
+
+// ociReference is a parsed reference to a manifest in a registry.
+type ociReference struct {
+	registry   string
+	repository string
+	reference  string // tag or digest
+	user       *url.Userinfo
+	plainHTTP  bool
+	fragment   url.Values
+}
+
+// parseOCIReference parses oci://registry/repository[:tag|@digest]. The
+// fragment option plain_http=true uses http instead of https. All other
+// fragment options are returned for the caller to handle.
+func parseOCIReference(source string) (*ociReference, error) {
+	u, err := url.Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("registry is missing")
+	}
+	ref := &ociReference{
+		registry: u.Host,
+		user:     u.User,
+	}
+
+	repository := strings.TrimPrefix(u.Path, "/")
+	if i := strings.Index(repository, "@"); i >= 0 {
+		ref.repository, ref.reference = repository[:i], repository[i+1:]
+		if !strings.HasPrefix(ref.reference, "sha256:") {
+			return nil, fmt.Errorf("invalid digest %s: only sha256 is supported", ref.reference)
+		}
+	} else if i := strings.LastIndex(repository, ":"); i >= 0 && !strings.Contains(repository[i:], "/") {
+		ref.repository, ref.reference = repository[:i], repository[i+1:]
+	} else {
+		ref.repository, ref.reference = repository, "latest"
+	}
+	if ref.repository == "" {
+		return nil, fmt.Errorf("repository is missing")
+	}
+
+	ref.fragment, err = url.ParseQuery(u.EscapedFragment())
+	if err != nil {
+		return nil, err
+	}
+	if v := ref.fragment.Get("plain_http"); v != "" {
+		ref.plainHTTP = v == "true"
+		ref.fragment.Del("plain_http")
+	}
+	return ref, nil
+}
+
+func (r *ociReference) url(kind, reference string) string {
+	scheme := "https"
+	if r.plainHTTP {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s/v2/%s/%s/%s", scheme, r.registry, r.repository, kind, reference)
+}
+
+func (d *ociDownloader) Download(path, source string) error {
+	ref, err := parseOCIReference(source)
+	if err != nil {
+		return err
+	}
+	for k := range ref.fragment {
+		return fmt.Errorf("invalid fragment %s: only plain_http is supported", k)
+	}
+	client := &registryClient{HTTPClient: d.HTTPClient, ref: ref}
+	manifest, err := client.manifest(ref.reference)
+	if err != nil {
+		return err
+	}
+	if len(manifest.Manifests) > 0 {
+		return fmt.Errorf("%s is an index, only manifests are supported", ref.reference)
+	}
+
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return fmt.Errorf("couldn't create directory %s: %w", path, err)
+	}
+	for _, layer := range manifest.Layers {
+		if err := d.pullLayer(client, layer, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *ociDownloader) pullLayer(client *registryClient, layer ociDescriptor, path string) error {
+	title := layer.Annotations[ociTitleAnnotation]
+	if title != "" && !isArchiveMediaType(layer.MediaType) {
+		if !filepath.IsLocal(title) {
+			return fmt.Errorf("invalid title %s for layer %s: needs to be a relative path", title, layer.Digest)
+		}
+		return client.blobToFile(layer, filepath.Join(path, title))
+	}
+	return client.blob(layer, func(r io.Reader) error {
+		if err := extractTar(r, path); err != nil {
+			return fmt.Errorf("couldn't extract layer %s: %w", layer.Digest, err)
+		}
+		return nil
+	})
+}
+
+func isArchiveMediaType(mediaType string) bool {
+	return strings.Contains(mediaType, "tar") || strings.HasSuffix(mediaType, "gzip")
+}
+
+// registryClient talks to an OCI distribution API, handling token
+// authentication and digest verification.
+type registryClient struct {
+	HTTPClient *http.Client
+	ref        *ociReference
+	token      string
+}
+
+// manifest returns the manifest for reference. The digest of the manifest is
+// verified if reference is a digest.
+func (c *registryClient) manifest(reference string) (*ociManifest, error) {
+	req, err := http.NewRequest(http.MethodGet, c.ref.url("manifests", reference), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join([]string{ociManifestMediaType, ociIndexMediaType, dockerManifestMediaType, dockerListMediaType}, ", "))
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return nil, fmt.Errorf("couldn't get manifest %s: %w", reference, err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(reference, "sha256:") {
+		if digest := sha256Digest(body); digest != reference {
+			return nil, fmt.Errorf("manifest digest mismatch: expected %s, got %s", reference, digest)
+		}
+	}
+	var manifest ociManifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return nil, fmt.Errorf("couldn't parse manifest %s: %w", reference, err)
+	}
+	return &manifest, nil
+}
+
+// blob downloads the blob to a temporary file, verifies its digest and size
+// and passes it to fn.
+func (c *registryClient) blob(desc ociDescriptor, fn func(io.Reader) error) error {
+	fh, err := os.CreateTemp("", "blob")
+	if err != nil {
+		return fmt.Errorf("couldn't create temporary file: %w", err)
+	}
+	defer os.Remove(fh.Name())
+	defer fh.Close()
+
+	if err := c.fetchBlob(desc, fh); err != nil {
+		return err
+	}
+	if _, err := fh.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return fn(fh)
+}
+
+// blobToFile downloads the blob to path and verifies its digest and size.
+func (c *registryClient) blobToFile(desc ociDescriptor, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("couldn't create directory %s: %w", filepath.Dir(path), err)
+	}
+	fh, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("couldn't create file %s: %w", path, err)
+	}
+	defer fh.Close()
+	if err := c.fetchBlob(desc, fh); err != nil {
+		os.Remove(path)
+		return err
+	}
+	return nil
+}
+
+func (c *registryClient) fetchBlob(desc ociDescriptor, w io.Writer) error {
+	if !strings.HasPrefix(desc.Digest, "sha256:") {
+		return fmt.Errorf("invalid digest %s: only sha256 is supported", desc.Digest)
+	}
+	req, err := http.NewRequest(http.MethodGet, c.ref.url("blobs", desc.Digest), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return fmt.Errorf("couldn't get blob %s: %w", desc.Digest, err)
+	}
+
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, h), resp.Body)
+	if err != nil {
+		return fmt.Errorf("couldn't download blob %s: %w", desc.Digest, err)
+	}
+	if n != desc.Size {
+		return fmt.Errorf("blob size mismatch for %s: expected %d, got %d", desc.Digest, desc.Size, n)
+	}
+	if digest := "sha256:" + hex.EncodeToString(h.Sum(nil)); digest != desc.Digest {
+		return fmt.Errorf("blob digest mismatch: expected %s, got %s", desc.Digest, digest)
+	}
+	return nil
+}
+
+// do sends req, authenticating if the registry asks for it.
+func (c *registryClient) do(req *http.Request) (*http.Response, error) {
+	c.authorize(req)
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+
+	scheme, params := parseAuthChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "bearer":
+		if err := c.fetchToken(params); err != nil {
+			return nil, err
+		}
+	case "basic":
+		if c.ref.user == nil {
+			return nil, fmt.Errorf("registry %s requires credentials", c.ref.registry)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported authentication challenge %q", challenge)
+	}
+	retry := req.Clone(req.Context())
+	c.authorize(retry)
+	return c.HTTPClient.Do(retry)
+}
+
+func (c *registryClient) authorize(req *http.Request) {
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+		return
+	}
+	if c.ref.user != nil {
+		password, _ := c.ref.user.Password()
+		req.SetBasicAuth(c.ref.user.Username(), password)
+	}
+}
+
+// fetchToken gets a bearer token from the realm of a challenge.
+func (c *registryClient) fetchToken(params map[string]string) error {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return fmt.Errorf("invalid token realm %q", params["realm"])
+	}
+	query := realm.Query()
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = "repository:" + c.ref.repository + ":pull"
+	}
+	query.Set("scope", scope)
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return err
+	}
+	if c.ref.user != nil {
+		password, _ := c.ref.user.Password()
+		req.SetBasicAuth(c.ref.user.Username(), password)
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := decodeJSONResponse(resp, &token); err != nil {
+		return fmt.Errorf("couldn't get registry token: %w", err)
+	}
+	c.token = token.Token
+	if c.token == "" {
+		c.token = token.AccessToken
+	}
+	if c.token == "" {
+		return fmt.Errorf("couldn't get registry token: no token in response")
+	}
+	return nil
+}
+
+// parseAuthChallenge parses a WWW-Authenticate header like
+// `Bearer realm="https://auth.docker.io/token",service="registry.docker.io"`.
+func parseAuthChallenge(challenge string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(challenge, " ")
+	params := make(map[string]string)
+	for rest != "" {
+		var kv string
+		rest = strings.TrimLeft(rest, " ,")
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+		if strings.HasPrefix(value, `"`) {
+			kv, rest, _ = strings.Cut(value[1:], `"`)
+		} else {
+			kv, rest, _ = strings.Cut(value, ",")
+		}
+		params[strings.ToLower(strings.TrimSpace(key))] = kv
+	}
+	return scheme, params
+}
+
+func sha256Digest(b []byte) string {
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
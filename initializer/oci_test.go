@@ -0,0 +1,287 @@
+package initializer
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// fakeRegistry is a minimal in-process OCI distribution registry requiring
+// token authentication.
+type fakeRegistry struct {
+	repository string
+	manifests  map[string][]byte // by tag and digest
+	blobs      map[string][]byte
+	user       string
+	password   string
+}
+
+func (r *fakeRegistry) push(tag string, manifest interface{}) string {
+	body, err := json.Marshal(manifest)
+	if err != nil {
+		panic(err)
+	}
+	digest := sha256Digest(body)
+	r.manifests[tag] = body
+	r.manifests[digest] = body
+	return digest
+}
+
+func (r *fakeRegistry) pushBlob(mediaType string, content []byte, annotations map[string]string) ociDescriptor {
+	digest := sha256Digest(content)
+	r.blobs[digest] = content
+	return ociDescriptor{MediaType: mediaType, Digest: digest, Size: int64(len(content)), Annotations: annotations}
+}
+
+func (r *fakeRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		user, password, _ := req.BasicAuth()
+		if user != r.user || password != r.password || req.URL.Query().Get("scope") != "repository:"+r.repository+":pull" {
+			http.Error(w, "invalid credentials", http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"token": "registry-token"}`)
+		return
+	}
+	if req.Header.Get("Authorization") != "Bearer registry-token" {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="http://%s/token",service="fake",scope="repository:%s:pull"`, req.Host, r.repository))
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	prefix := "/v2/" + r.repository + "/"
+	switch {
+	case strings.HasPrefix(req.URL.Path, prefix+"manifests/"):
+		body, ok := r.manifests[strings.TrimPrefix(req.URL.Path, prefix+"manifests/")]
+		if !ok {
+			http.Error(w, "MANIFEST_UNKNOWN", http.StatusNotFound)
+			return
+		}
+		w.Write(body)
+	case strings.HasPrefix(req.URL.Path, prefix+"blobs/"):
+		body, ok := r.blobs[strings.TrimPrefix(req.URL.Path, prefix+"blobs/")]
+		if !ok {
+			http.Error(w, "BLOB_UNKNOWN", http.StatusNotFound)
+			return
+		}
+		w.Write(body)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func newFakeRegistry(repository string) *fakeRegistry {
+	return &fakeRegistry{
+		repository: repository,
+		manifests:  make(map[string][]byte),
+		blobs:      make(map[string][]byte),
+		user:       "user",
+		password:   "pass",
+	}
+}
+
+type tarEntry struct {
+	name     string
+	content  string
+	typeflag byte
+	linkname string
+}
+
+func tarGz(t *testing.T, entries ...tarEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.content)), Typeflag: e.typeflag, Linkname: e.linkname}
+		if e.typeflag == 0 {
+			hdr.Typeflag = tar.TypeReg
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			hdr.Mode = 0755
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// readTree returns the content of all regular files below dir.
+func readTree(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = string(content)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestOCIDownloader(t *testing.T) {
+	registry := newFakeRegistry("team/agent")
+	var (
+		model  = registry.pushBlob("application/octet-stream", []byte("weights"), map[string]string{ociTitleAnnotation: "model.bin"})
+		code   = registry.pushBlob("application/vnd.oci.image.layer.v1.tar+gzip", tarGz(t, tarEntry{name: "src/", typeflag: tar.TypeDir}, tarEntry{name: "src/agent.py", content: "print('hi')"}), map[string]string{ociTitleAnnotation: "src"})
+		config = registry.pushBlob("application/vnd.oci.empty.v1+json", []byte("{}"), nil)
+	)
+	digest := registry.push("v1", ociManifest{MediaType: ociManifestMediaType, Config: config, Layers: []ociDescriptor{model, code}})
+
+	corrupt := model
+	corrupt.Digest = sha256Digest([]byte("something else"))
+	registry.blobs[corrupt.Digest] = []byte("weights")
+	registry.push("corrupt", ociManifest{MediaType: ociManifestMediaType, Config: config, Layers: []ociDescriptor{corrupt}})
+
+	server := httptest.NewServer(registry)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	expected := map[string]string{"model.bin": "weights", "src/agent.py": "print('hi')"}
+	for _, tc := range []struct {
+		name        string
+		source      string
+		expected    map[string]string
+		expectedErr string
+	}{
+		{
+			name:     "tag",
+			source:   "oci://user:pass@" + host + "/team/agent:v1#plain_http=true",
+			expected: expected,
+		},
+		{
+			name:     "digest",
+			source:   "oci://user:pass@" + host + "/team/agent@" + digest + "#plain_http=true",
+			expected: expected,
+		},
+		{
+			name:        "wrong credentials",
+			source:      "oci://user:wrong@" + host + "/team/agent:v1#plain_http=true",
+			expectedErr: "couldn't get registry token: unexpected status code 401: invalid credentials\n",
+		},
+		{
+			name:        "missing tag",
+			source:      "oci://user:pass@" + host + "/team/agent:v2#plain_http=true",
+			expectedErr: "couldn't get manifest v2: unexpected status code 404: MANIFEST_UNKNOWN\n",
+		},
+		{
+			name:        "corrupt blob",
+			source:      "oci://user:pass@" + host + "/team/agent:corrupt#plain_http=true",
+			expectedErr: fmt.Sprintf("blob digest mismatch: expected %s, got %s", corrupt.Digest, model.Digest),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "dest")
+			err := (&ociDownloader{HTTPClient: server.Client()}).Download(path, tc.source)
+			if tc.expectedErr != "" {
+				if err == nil || err.Error() != tc.expectedErr {
+					t.Fatalf("expected error %q, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := readTree(t, path)
+			for name, content := range tc.expected {
+				if got[name] != content {
+					t.Errorf("unexpected content in %s: %q", name, got[name])
+				}
+			}
+			if len(got) != len(tc.expected) {
+				var names []string
+				for name := range got {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+				t.Errorf("unexpected files: %v", names)
+			}
+		})
+	}
+}
+
+func TestExtractTarRejectsEscapes(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		entries []tarEntry
+	}{
+		{
+			name:    "parent path",
+			entries: []tarEntry{{name: "../evil", content: "x"}},
+		},
+		{
+			name:    "absolute symlink",
+			entries: []tarEntry{{name: "link", typeflag: tar.TypeSymlink, linkname: "/etc"}},
+		},
+		{
+			name: "write through symlink",
+			entries: []tarEntry{
+				{name: "link", typeflag: tar.TypeSymlink, linkname: "."},
+				{name: "link/evil", typeflag: tar.TypeSymlink, linkname: "../x"},
+			},
+		},
+		{
+			name: "chained symlinks",
+			entries: []tarEntry{
+				{name: "d/", typeflag: tar.TypeDir},
+				{name: "d/b", typeflag: tar.TypeSymlink, linkname: ".."},
+				{name: "d/c", typeflag: tar.TypeSymlink, linkname: "b/.."},
+				{name: "h", typeflag: tar.TypeLink, linkname: "d/c/outside"},
+			},
+		},
+		{
+			name: "hardlink to symlink",
+			entries: []tarEntry{
+				{name: "d/", typeflag: tar.TypeDir},
+				{name: "d/up", typeflag: tar.TypeSymlink, linkname: ".."},
+				{name: "up", typeflag: tar.TypeLink, linkname: "d/up"},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dest := filepath.Join(t.TempDir(), "dest")
+			if err := os.WriteFile(filepath.Join(filepath.Dir(dest), "outside"), []byte("secret"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			err := extractTar(bytes.NewReader(tarGz(t, tc.entries...)), dest)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			for _, name := range []string{"d/c/outside", "h"} {
+				if content, err := os.ReadFile(filepath.Join(dest, name)); err == nil {
+					t.Errorf("%s escapes destination: %q", name, content)
+				}
+			}
+		})
+	}
+}
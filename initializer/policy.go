@@ -0,0 +1,214 @@
+package initializer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5/config"
+)
+
+// ErrPolicyViolation is returned when a source or a redirect points to a
+// host not allowed by the HostPolicy.
+var ErrPolicyViolation = errors.New("policy violation")
+
+// HostPolicy restricts the hosts sources may be downloaded from. Patterns
+// are globs for the host, optionally prefixed by a scheme, like github.com,
+// *.huggingface.co or s3://my-bucket.s3.*.amazonaws.com. A host matching any
+// Deny pattern is rejected. If Allow isn't empty, hosts need to match one of
+// its patterns. Buckets are only part of the host for virtual hosted s3, so
+// gs and path style s3 can't be limited to single buckets.
+type HostPolicy struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// ParseHostPolicy parses a policy from json like
+// {"allow": ["github.com"], "deny": ["*.internal"]}.
+func ParseHostPolicy(data []byte) (*HostPolicy, error) {
+	var p HostPolicy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("invalid host policy: %w", err)
+	}
+	// Hosts are case insensitive, so they are matched in lowercase.
+	for i := range p.Allow {
+		p.Allow[i] = strings.ToLower(p.Allow[i])
+	}
+	for i := range p.Deny {
+		p.Deny[i] = strings.ToLower(p.Deny[i])
+	}
+	for _, pattern := range append(append([]string{}, p.Allow...), p.Deny...) {
+		_, host := splitPattern(pattern)
+		if _, err := path.Match(host, ""); err != nil {
+			return nil, fmt.Errorf("invalid host policy: invalid pattern %s: %w", pattern, err)
+		}
+	}
+	return &p, nil
+}
+
+// ReadHostPolicy reads a policy from a json file.
+func ReadHostPolicy(file string) (*HostPolicy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read host policy: %w", err)
+	}
+	return ParseHostPolicy(data)
+}
+
+func splitPattern(pattern string) (string, string) {
+	if scheme, host, ok := strings.Cut(pattern, "://"); ok {
+		return scheme, host
+	}
+	return "", pattern
+}
+
+// matchesAny returns true if the host of u matches any of the patterns. The
+// host is lowercased and a trailing dot of fully qualified names removed, so
+// EVIL.com and evil.com. match evil.com.
+func matchesAny(patterns []string, scheme string, u *url.URL) bool {
+	hostname := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	host := hostname
+	if port := u.Port(); port != "" {
+		host = net.JoinHostPort(hostname, port)
+	}
+	for _, pattern := range patterns {
+		s, p := splitPattern(strings.ToLower(pattern))
+		if s != "" && s != scheme {
+			continue
+		}
+		if ok, _ := path.Match(p, host); ok {
+			return true
+		}
+		if ok, _ := path.Match(p, hostname); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns an error if the host of u isn't allowed for scheme.
+func (p *HostPolicy) check(scheme string, u *url.URL) error {
+	if matchesAny(p.Deny, scheme, u) || (len(p.Allow) > 0 && !matchesAny(p.Allow, scheme, u)) {
+		return fmt.Errorf("%w: host %s is not allowed for %s", ErrPolicyViolation, u.Host, scheme)
+	}
+	return nil
+}
+
+// checkMirror checks the host a source is downloaded from. Local sources
+// have no host and are always allowed. git urls are checked with their
+// transport as scheme. Object storages and APIs are checked with the host
+// of their endpoint, which needs to match an allow pattern explicitly if it
+// is overridden in the fragment.
+func (p *HostPolicy) checkMirror(m mirror) error {
+	switch m.url.Scheme {
+	case "", "file", "data", "literal":
+		return nil
+	case "git":
+		return p.check(m.processor, m.url)
+	}
+	u, overridden, err := endpoint(m.url)
+	if err != nil {
+		return err
+	}
+	if overridden && !matchesAny(p.Allow, m.url.Scheme, u) {
+		return fmt.Errorf("%w: endpoint %s is not allowed for %s", ErrPolicyViolation, u.Host, m.url.Scheme)
+	}
+	return p.check(m.url.Scheme, u)
+}
+
+// endpoint returns the url sources are actually downloaded from and whether
+// it was overridden by the endpoint or api fragment.
+func endpoint(u *url.URL) (*url.URL, bool, error) {
+	var e string
+	switch u.Scheme {
+	case "s3":
+		src, err := parseS3Source(u.String())
+		if err != nil {
+			return nil, false, err
+		}
+		e = src.objectURL("").String()
+	case "gs":
+		src, err := parseGCSSource(u.String())
+		if err != nil {
+			return nil, false, err
+		}
+		e = src.endpoint
+	case "az":
+		src, err := parseAzureSource(u.String())
+		if err != nil {
+			return nil, false, err
+		}
+		e = src.endpoint
+	case "hf":
+		src, err := parseHFSource(u.String())
+		if err != nil {
+			return nil, false, err
+		}
+		e = src.endpoint
+	case "github-release":
+		src, err := parseGitHubReleaseSource(u.String())
+		if err != nil {
+			return nil, false, err
+		}
+		e = src.api
+	default:
+		return u, false, nil
+	}
+	parsed, err := url.Parse(e)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid endpoint %s: %w", e, err)
+	}
+	values, _ := url.ParseQuery(u.EscapedFragment())
+	return parsed, values.Has("endpoint") || values.Has("api"), nil
+}
+
+// checkSubmodules checks the urls of the submodules in the repository at
+// dir. Relative urls are resolved against the url of the repository.
+func (p *HostPolicy) checkSubmodules(dir string, repo *url.URL) error {
+	data, err := os.ReadFile(filepath.Join(dir, ".gitmodules"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't read .gitmodules: %w", err)
+	}
+	modules := config.NewModules()
+	if err := modules.Unmarshal(data); err != nil {
+		return fmt.Errorf("couldn't parse .gitmodules: %w", err)
+	}
+	for name, submodule := range modules.Submodules {
+		u, err := parseSubmoduleURL(submodule.URL, repo)
+		if err != nil {
+			return fmt.Errorf("invalid url for submodule %s: %w", name, err)
+		}
+		if err := p.check(u.Scheme, u); err != nil {
+			return fmt.Errorf("submodule %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// parseSubmoduleURL parses urls, scp like ssh urls like
+// git@github.com:org/repo.git and urls relative to repo.
+func parseSubmoduleURL(s string, repo *url.URL) (*url.URL, error) {
+	if strings.HasPrefix(s, "./") || strings.HasPrefix(s, "../") {
+		u := *repo
+		u.Path = path.Join(u.Path, s)
+		return &u, nil
+	}
+	if !strings.Contains(s, "://") {
+		if hostPart, _, ok := strings.Cut(s, ":"); ok && !strings.Contains(hostPart, "/") {
+			if _, host, ok := strings.Cut(hostPart, "@"); ok {
+				hostPart = host
+			}
+			return &url.URL{Scheme: "ssh", Host: hostPart}, nil
+		}
+	}
+	return url.Parse(s)
+}
@@ -0,0 +1,144 @@
+package initializer
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+)
+
+func TestHostPolicyValidate(t *testing.T) {
+	policy, err := ParseHostPolicy([]byte(`{
+		"allow": ["github.com", "api.github.com", "huggingface.co", "*.huggingface.co", "s3://models.s3.*.amazonaws.com", "s3://minio.internal", "ssh://gitlab.com", "127.0.0.1"],
+		"deny": ["evil.huggingface.co"]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for source, allowed := range map[string]bool{
+		"https://github.com/foo/bar":                                      true,
+		"https://gitlab.com/foo/bar":                                      false,
+		"https://cdn.huggingface.co/model":                                true,
+		"https://evil.huggingface.co/model":                               false,
+		"s3://models/foo":                                                 true,
+		"s3://other/foo":                                                  false,
+		"gs://models/foo":                                                 false,
+		"hf://org/model":                                                  true,
+		"hf://org/model#endpoint=https://evil.example":                    false,
+		"s3://models/foo#endpoint=https://evil.example":                   false,
+		"s3://other/foo#endpoint=https://minio.internal&path_style=true":  true,
+		"gs://models/foo#endpoint=https://evil.example":                   false,
+		"az://account/models/foo#endpoint=https://evil.example":           false,
+		"github-release://org/repo@v1/model.bin":                          true,
+		"github-release://org/repo@v1/model.bin#api=https://evil.example": false,
+		"git+ssh://git@gitlab.com/foo/bar.git":                            true,
+		"git+https://gitlab.com/foo/bar.git":                              false,
+		"git+https://github.com/foo/bar.git":                              true,
+		"http://127.0.0.1:8080/foo":                                       true,
+		"/etc/hosts":                                                      true,
+		"data:,foo":                                                       true,
+		"https://github.com/a https://gitlab.com/b#sha256=" + strings.Repeat("0", 64): false,
+	} {
+		err := (&Sources{"file": source}).ValidateWithPolicy(policy)
+		if allowed && err != nil {
+			t.Errorf("%s: expected to be allowed, got %v", source, err)
+		}
+		if !allowed && !errors.Is(err, ErrPolicyViolation) {
+			t.Errorf("%s: expected policy violation, got %v", source, err)
+		}
+	}
+
+	// Hosts are matched case insensitively and without a trailing dot.
+	deny, err := ParseHostPolicy([]byte(`{"deny": ["evil.com", "*.EVIL.org"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, source := range []string{"https://evil.com/x", "https://EVIL.com/x", "https://evil.com./x", "https://Evil.Com.:443/x", "https://cdn.evil.org./x"} {
+		if err := (&Sources{"file": source}).ValidateWithPolicy(deny); !errors.Is(err, ErrPolicyViolation) {
+			t.Errorf("%s: expected policy violation, got %v", source, err)
+		}
+	}
+	if err := (&Sources{"file": "https://GitHub.com./foo"}).ValidateWithPolicy(policy); err != nil {
+		t.Errorf("expected mixed case host to be allowed, got %v", err)
+	}
+
+	// Overridden endpoints need to be allowed explicitly, not only not denied.
+	err = (&Sources{"file": "s3://models/foo#endpoint=https://evil.example"}).ValidateWithPolicy(&HostPolicy{Deny: []string{"*.internal"}})
+	if !errors.Is(err, ErrPolicyViolation) {
+		t.Errorf("expected policy violation for endpoint override, got %v", err)
+	}
+
+	if _, err := ParseHostPolicy([]byte(`{"allow": ["[github.com"]}`)); err == nil {
+		t.Error("expected error for invalid pattern")
+	}
+	if err := (&Sources{"file": "https://gitlab.com/foo"}).Validate(); err != nil {
+		t.Errorf("expected no policy without one, got %v", err)
+	}
+}
+
+func TestHostPolicyRedirect(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "http://example.com/file", http.StatusFound)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	policy := &HostPolicy{Allow: []string{"127.0.0.1"}}
+
+	init, err := NewInitializer(log.NewNopLogger(), map[string]string{"file": server.URL + "/file"}, nil, nil, t.TempDir(), WithHostPolicy(policy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := init.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	init, err = NewInitializer(log.NewNopLogger(), map[string]string{"file": server.URL + "/redirect#header=X-Foo:bar"}, nil, nil, t.TempDir(), WithHostPolicy(policy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := init.Init(); !errors.Is(err, ErrPolicyViolation) || !strings.Contains(err.Error(), "host example.com is not allowed for http") {
+		t.Fatalf("expected policy violation for redirect, got %v", err)
+	}
+
+	_, err = NewInitializer(log.NewNopLogger(), map[string]string{"file": "https://example.com/file"}, nil, nil, t.TempDir(), WithHostPolicy(policy))
+	if !errors.Is(err, ErrPolicyViolation) {
+		t.Fatalf("expected policy violation, got %v", err)
+	}
+}
+
+func TestHostPolicySubmodules(t *testing.T) {
+	repo, _ := url.Parse("https://github.com/org/repo.git")
+	policy := &HostPolicy{Allow: []string{"github.com"}}
+	for modules, allowed := range map[string]bool{
+		"":                                   true,
+		"url = https://github.com/org/lib":   true,
+		"url = ../lib.git":                   true,
+		"url = git@github.com:org/lib.git":   true,
+		"url = https://gitlab.com/org/lib":   false,
+		"url = git@gitlab.com:org/lib.git":   false,
+		"url = ssh://git@gitlab.com/lib.git": false,
+	} {
+		dir := t.TempDir()
+		if modules != "" {
+			if err := os.WriteFile(filepath.Join(dir, ".gitmodules"), []byte("[submodule \"lib\"]\n\tpath = lib\n\t"+modules+"\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		err := policy.checkSubmodules(dir, repo)
+		if allowed && err != nil {
+			t.Errorf("%q: expected to be allowed, got %v", modules, err)
+		}
+		if !allowed && !errors.Is(err, ErrPolicyViolation) {
+			t.Errorf("%q: expected policy violation, got %v", modules, err)
+		}
+	}
+}
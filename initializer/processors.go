@@ -8,6 +8,9 @@ import (
 	"path/filepath"
 )
 
+// Processor processes a download in place. The path passed to Process
+// includes the root of the Initializer, so it doesn't depend on the working
+// directory.
 type Processor interface {
 	Process(path string) error
 }
@@ -1,11 +1,36 @@
 package initializer
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/google/go-cmp/cmp"
 )
 
+func TestZipProcessorBelowRoot(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(compress(t, "unzip", []byte("hello world\n")))
+	}))
+	defer server.Close()
+
+	root := t.TempDir()
+	init, err := NewInitializer(log.NewNopLogger(), map[string]string{"archive": "http+zip" + strings.TrimPrefix(server.URL, "http") + "/test.zip"}, nil, nil, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := init.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(map[string]string{"archive/hello": "hello world\n"}, readTree(t, root)); diff != "" {
+		t.Fatalf("unexpected files (-want +got):\n%s", diff)
+	}
+}
+
 func TestZipProcessor(t *testing.T) {
 
 	expectedContents := map[string]string{
@@ -0,0 +1,104 @@
+package initializer
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+)
+
+// defaultProgressInterval is the minimum time between two progress messages
+// for the same phase.
+const defaultProgressInterval = time.Second
+
+// progressRegexp matches git progress lines like
+// "Receiving objects:  45% (450/1000), 1.20 MiB | 2.00 MiB/s".
+var progressRegexp = regexp.MustCompile(`^(?:remote: )?([A-Za-z ]+):\s+(\d+)% \((\d+/\d+)\)(?:, ([\d.]+ [KMGT]?i?B))?`)
+
+// logWriter logs git output line by line. Lines may be terminated by '\n' or
+// by '\r' which git uses to update progress in place. Progress lines are
+// logged as structured messages, rate limited to one per interval.
+type logWriter struct {
+	logger   log.Logger
+	redactor *redactor
+	interval time.Duration
+	now      func() time.Time
+
+	mu           sync.Mutex
+	buf          []byte
+	lastPhase    string
+	lastProgress time.Time
+}
+
+func newLogWriter(logger log.Logger, r *redactor) *logWriter {
+	return &logWriter{
+		logger:   logger,
+		redactor: r,
+		interval: defaultProgressInterval,
+		now:      time.Now,
+	}
+}
+
+func (l *logWriter) Write(p []byte) (n int, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.buf = append(l.buf, p...)
+	for {
+		i := strings.IndexAny(string(l.buf), "\r\n")
+		if i < 0 {
+			break
+		}
+		final := l.buf[i] == '\n'
+		l.logLine(string(l.buf[:i]), final)
+		l.buf = l.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush logs any buffered output not terminated by a newline.
+func (l *logWriter) Flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.buf) > 0 {
+		l.logLine(string(l.buf), true)
+		l.buf = nil
+	}
+}
+
+// logLine logs a single line. Progress updates which are not final are
+// dropped if the last one for the same phase was logged less than interval
+// ago.
+func (l *logWriter) logLine(line string, final bool) {
+	line = l.redactor.redact(strings.TrimSpace(line))
+	if line == "" {
+		return
+	}
+	m := progressRegexp.FindStringSubmatch(line)
+	if m == nil {
+		l.logger.Log("msg", line)
+		return
+	}
+
+	var (
+		phase      = m[1]
+		percent, _ = strconv.Atoi(m[2])
+		done       = final || strings.HasSuffix(line, "done.")
+		now        = l.now()
+	)
+	if !done && phase == l.lastPhase && now.Sub(l.lastProgress) < l.interval {
+		return
+	}
+	l.lastPhase = phase
+	l.lastProgress = now
+
+	keyvals := []interface{}{"msg", "progress", "phase", phase, "percent", percent, "objects", m[3]}
+	if m[4] != "" {
+		keyvals = append(keyvals, "bytes", m[4])
+	}
+	l.logger.Log(keyvals...)
+}
@@ -0,0 +1,52 @@
+package initializer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLogWriter(t *testing.T) {
+	var (
+		sw  = &sliceWriter{}
+		now = time.Unix(0, 0)
+		w   = newLogWriter(log.NewLogfmtLogger(sw), newRedactor(map[string]string{"token": "abcd"}))
+	)
+	w.now = func() time.Time { return now }
+
+	for _, write := range []string{
+		"Cloning into 'foo'...\n",
+		"fatal: unable to access 'https://abcd@host/': ",
+		"error\n",
+		"remote: Counting objects: 100% (5/5), done.\n",
+		"Receiving objects:  10% (1/10)\r",
+		"Receiving objects:  20% (2/10)\r", // rate limited
+		"Receiving objects:  30% (3/10), 1.00 MiB | 1.00 MiB/s\r",
+		"Receiving objects: 100% (10/10), 2.50 MiB | 1.00 MiB/s, done.\n",
+		"Resolving deltas: 100% (1/1), done.\ntrailing",
+	} {
+		if write == "Receiving objects:  30% (3/10), 1.00 MiB | 1.00 MiB/s\r" {
+			now = now.Add(time.Second)
+		}
+		if _, err := w.Write([]byte(write)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Flush()
+
+	expected := []string{
+		`msg="Cloning into 'foo'..."`,
+		`msg="fatal: unable to access 'https://xxxxx@host/': error"`,
+		`msg=progress phase="Counting objects" percent=100 objects=5/5`,
+		`msg=progress phase="Receiving objects" percent=10 objects=1/10`,
+		`msg=progress phase="Receiving objects" percent=30 objects=3/10 bytes="1.00 MiB"`,
+		`msg=progress phase="Receiving objects" percent=100 objects=10/10 bytes="2.50 MiB"`,
+		`msg=progress phase="Resolving deltas" percent=100 objects=1/1`,
+		`msg=trailing`,
+	}
+	if diff := cmp.Diff(expected, sw.slices); diff != "" {
+		t.Errorf("log mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,107 @@
+package initializer
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/go-kit/log"
+)
+
+// redactor masks known secret values in strings.
+type redactor struct {
+	replacer *strings.Replacer
+}
+
+// newRedactor returns a redactor masking the secrets values as well as their
+// url and base64 encoded forms.
+func newRedactor(secrets map[string]string) *redactor {
+	seen := make(map[string]bool)
+	values := make([]string, 0, len(secrets))
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		for _, v := range []string{
+			secret,
+			url.QueryEscape(secret),
+			url.PathEscape(secret),
+			base64.StdEncoding.EncodeToString([]byte(secret)),
+			base64.RawStdEncoding.EncodeToString([]byte(secret)),
+			base64.URLEncoding.EncodeToString([]byte(secret)),
+			base64.RawURLEncoding.EncodeToString([]byte(secret)),
+		} {
+			if !seen[v] {
+				seen[v] = true
+				values = append(values, v)
+			}
+		}
+	}
+	// Replace longer secrets first so a secret containing another one is
+	// fully masked.
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+
+	oldnew := make([]string, 0, 2*len(values))
+	for _, v := range values {
+		oldnew = append(oldnew, v, redactedPlaceholder)
+	}
+	return &redactor{replacer: strings.NewReplacer(oldnew...)}
+}
+
+func (r *redactor) redact(s string) string {
+	if r == nil {
+		return s
+	}
+	return r.replacer.Replace(s)
+}
+
+// redactError returns err with all secrets masked in its message. The
+// original error is still available via errors.Is and errors.As.
+func (r *redactor) redactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := r.redact(err.Error())
+	if msg == err.Error() {
+		return err
+	}
+	return &redactedError{err: err, msg: msg}
+}
+
+type redactedError struct {
+	err error
+	msg string
+}
+
+func (e *redactedError) Error() string { return e.msg }
+
+func (e *redactedError) Unwrap() error { return e.err }
+
+// redactingLogger masks secrets in all keys and values logged.
+type redactingLogger struct {
+	logger   log.Logger
+	redactor *redactor
+}
+
+func newRedactingLogger(logger log.Logger, r *redactor) log.Logger {
+	return &redactingLogger{logger: logger, redactor: r}
+}
+
+func (l *redactingLogger) Log(keyvals ...interface{}) error {
+	redacted := make([]interface{}, len(keyvals))
+	for i, kv := range keyvals {
+		switch v := kv.(type) {
+		case string:
+			redacted[i] = l.redactor.redact(v)
+		case error:
+			redacted[i] = l.redactor.redact(v.Error())
+		case fmt.Stringer:
+			redacted[i] = l.redactor.redact(v.String())
+		default:
+			redacted[i] = kv
+		}
+	}
+	return l.logger.Log(redacted...)
+}
@@ -0,0 +1,79 @@
+package initializer
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// ErrRedirectNotAllowed is returned when a redirect isn't allowed by the
+// RedirectPolicy.
+var ErrRedirectNotAllowed = errors.New("redirect not allowed")
+
+// credentialHeaders are removed from redirects to another host.
+var credentialHeaders = []string{"Authorization", "Cookie"}
+
+// RedirectPolicy configures how http redirects are followed. MaxHops of 0
+// disables redirects. SameHost only allows redirects to the host of the
+// source and HTTPSOnly rejects redirects from https to http.
+type RedirectPolicy struct {
+	MaxHops   int
+	SameHost  bool
+	HTTPSOnly bool
+}
+
+// DefaultRedirectPolicy follows up to 10 redirects. Unlike http.DefaultClient,
+// which stops after 10 requests, MaxHops counts the redirects only.
+var DefaultRedirectPolicy = RedirectPolicy{MaxHops: 10}
+
+// check returns an error if the redirect to req isn't allowed and removes
+// credentials from it when it leaves the host of the source.
+func (p RedirectPolicy) check(req *http.Request, via []*http.Request) error {
+	from := via[len(via)-1].URL
+	if len(via) > p.MaxHops {
+		return fmt.Errorf("%w: stopped after %d redirects", ErrRedirectNotAllowed, p.MaxHops)
+	}
+	if p.HTTPSOnly && from.Scheme == "https" && req.URL.Scheme != "https" {
+		return fmt.Errorf("%w: %s downgrades to %s", ErrRedirectNotAllowed, redactRedirectURL(req.URL), req.URL.Scheme)
+	}
+	if req.URL.Host != via[0].URL.Host {
+		if p.SameHost {
+			return fmt.Errorf("%w: %s leaves host %s", ErrRedirectNotAllowed, redactRedirectURL(req.URL), via[0].URL.Host)
+		}
+		for _, name := range credentialHeaders {
+			req.Header.Del(name)
+		}
+	}
+	return nil
+}
+
+// redactRedirectURL returns the url without credentials and query values,
+// which often hold signatures of presigned urls.
+func redactRedirectURL(u *url.URL) string {
+	_, _, redacted, err := parseAndRedact(u.String())
+	if err != nil {
+		return u.Redacted()
+	}
+	return redacted
+}
+
+// checkRedirect returns a http.Client.CheckRedirect applying the redirect
+// and host policies and logging each hop.
+func checkRedirect(logger log.Logger, redirects RedirectPolicy, hosts *HostPolicy) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if err := redirects.check(req, via); err != nil {
+			return err
+		}
+		if hosts != nil {
+			if err := hosts.check(req.URL.Scheme, req.URL); err != nil {
+				return err
+			}
+		}
+		level.Info(logger).Log("msg", "following redirect", "from", redactRedirectURL(via[len(via)-1].URL), "to", redactRedirectURL(req.URL), "hop", len(via))
+		return nil
+	}
+}
@@ -0,0 +1,118 @@
+package initializer
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+)
+
+func TestRedirectPolicy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/hops":
+			http.Redirect(w, r, "/hop1?X-Amz-Signature=secret", http.StatusFound)
+		case "/hop1":
+			http.Redirect(w, r, "/file", http.StatusFound)
+		case "/other":
+			http.Redirect(w, r, strings.Replace("http://"+r.Host, "127.0.0.1", "localhost", 1)+"/file", http.StatusFound)
+		case "/downgrade":
+			http.Redirect(w, r, "http://"+r.Host+"/file", http.StatusFound)
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer server.Close()
+	tlsServer := httptest.NewTLSServer(server.Config.Handler)
+	defer tlsServer.Close()
+
+	for _, tc := range []struct {
+		name        string
+		source      string
+		policy      RedirectPolicy
+		expectedErr string
+	}{
+		{
+			name:   "default",
+			source: server.URL + "/hops",
+			policy: DefaultRedirectPolicy,
+		},
+		{
+			name:        "max hops",
+			source:      server.URL + "/hops",
+			policy:      RedirectPolicy{MaxHops: 1},
+			expectedErr: "stopped after 1 redirects",
+		},
+		{
+			name:        "disabled",
+			source:      server.URL + "/hops",
+			expectedErr: "stopped after 0 redirects",
+		},
+		{
+			name:   "other host",
+			source: server.URL + "/other",
+			policy: DefaultRedirectPolicy,
+		},
+		{
+			name:        "same host",
+			source:      server.URL + "/other",
+			policy:      RedirectPolicy{MaxHops: 10, SameHost: true},
+			expectedErr: "leaves host 127.0.0.1",
+		},
+		{
+			name:        "https only",
+			source:      tlsServer.URL + "/downgrade",
+			policy:      RedirectPolicy{MaxHops: 10, HTTPSOnly: true},
+			expectedErr: "downgrades to http",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var logs sliceWriter
+			init, err := NewInitializer(log.NewLogfmtLogger(&logs), map[string]string{"file": tc.source}, nil, nil, t.TempDir(), WithRedirectPolicy(tc.policy))
+			if err != nil {
+				t.Fatal(err)
+			}
+			init.HTTPDownloader.(*httpDownloader).HTTPClient.Transport = tlsServer.Client().Transport
+			err = init.Init()
+			if tc.expectedErr != "" {
+				if !errors.Is(err, ErrRedirectNotAllowed) || !strings.Contains(err.Error(), tc.expectedErr) {
+					t.Fatalf("expected error containing %q, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			output := strings.Join(logs.slices, "\n")
+			if !strings.Contains(output, "following redirect") {
+				t.Errorf("expected redirect to be logged, got %s", output)
+			}
+			if strings.Contains(output, "secret") {
+				t.Errorf("expected query to be redacted, got %s", output)
+			}
+		})
+	}
+}
+
+func TestRedirectStripsCredentials(t *testing.T) {
+	policy := DefaultRedirectPolicy
+	via := []*http.Request{httptest.NewRequest("GET", "https://example.com/file", nil)}
+	for target, stripped := range map[string]bool{
+		"https://example.com/other":     false,
+		"https://cdn.example.com/other": true,
+		"https://example.org/other":     true,
+	} {
+		req := httptest.NewRequest("GET", target, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		req.Header.Set("Cookie", "session=secret")
+		if err := policy.check(req, via); err != nil {
+			t.Fatal(err)
+		}
+		if stripped != (req.Header.Get("Authorization") == "" && req.Header.Get("Cookie") == "") {
+			t.Errorf("%s: expected credentials stripped=%v, got %v", target, stripped, req.Header)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package initializer
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	s3DefaultRegion = "us-east-1"
+	// emptySHA256 is the hex encoded sha256 of an empty payload.
+	emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// s3Downloader downloads objects from S3 compatible object storage. Sources
+// have the form s3://[access_key:secret_key@]bucket/key and are downloaded
+// recursively if the key is empty or ends with a slash. The fragment
+// configures region, endpoint, path_style and session_token.
+type s3Downloader struct {
+	HTTPClient *http.Client
+	now        func() time.Time
+}
+
+func newS3Downloader(client *http.Client) *s3Downloader {
+	return &s3Downloader{HTTPClient: client, now: time.Now}
+}
+
+type s3Source struct {
+	bucket       string
+	key          string
+	region       string
+	endpoint     *url.URL
+	pathStyle    bool
+	accessKey    string
+	secretKey    string
+	sessionToken string
+}
+
+func parseS3Source(source string) (*s3Source, error) {
+	u, err := url.Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("bucket is missing")
+	}
+	src := &s3Source{
+		bucket: u.Host,
+		key:    strings.TrimPrefix(u.Path, "/"),
+		region: s3DefaultRegion,
+	}
+	if u.User != nil {
+		src.accessKey = u.User.Username()
+		src.secretKey, _ = u.User.Password()
+	}
+
+	values, err := url.ParseQuery(u.EscapedFragment())
+	if err != nil {
+		return nil, err
+	}
+	endpoint := ""
+	for k, v := range values {
+		if len(v) != 1 {
+			return nil, fmt.Errorf("invalid fragment %s: only one value is supported", k)
+		}
+		switch k {
+		case "region":
+			src.region = v[0]
+		case "endpoint":
+			endpoint = v[0]
+		case "path_style":
+			src.pathStyle = v[0] == "true"
+		case "session_token":
+			src.sessionToken = v[0]
+		default:
+			return nil, fmt.Errorf("invalid fragment %s: only region, endpoint, path_style and session_token are supported", k)
+		}
+	}
+	if endpoint == "" {
+		endpoint = "https://s3." + src.region + ".amazonaws.com"
+	}
+	src.endpoint, err = url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint %s: %w", endpoint, err)
+	}
+	return src, nil
+}
+
+// objectURL returns the url for key, or the bucket if key is empty.
+func (s *s3Source) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	if s.pathStyle {
+		u.Path = "/" + s.bucket + "/" + key
+	} else {
+		u.Host = s.bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	u.RawPath = s3EscapePath(u.Path)
+	return &u
+}
+
+func (d *s3Downloader) Download(path, source string) error {
+	src, err := parseS3Source(source)
+	if err != nil {
+		return err
+	}
+	if src.key != "" && !strings.HasSuffix(src.key, "/") {
+		return d.downloadObject(path, src, src.key)
+	}
+
+	keys, err := d.list(src)
+	if err != nil {
+		return err
+	}
+	return downloadPrefix(path, src.key, keys, func(path, key string) error {
+		return d.downloadObject(path, src, key)
+	})
+}
+
+func (d *s3Downloader) downloadObject(path string, src *s3Source, key string) error {
+	req, err := http.NewRequest(http.MethodGet, src.objectURL(key).String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := d.do(req, src)
+	if err != nil {
+		return err
+	}
+	if err := saveResponse(path, resp); err != nil {
+		return fmt.Errorf("couldn't download s3://%s/%s: %w", src.bucket, key, err)
+	}
+	return nil
+}
+
+type s3ListBucketResult struct {
+	Contents []struct {
+		Key string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// list returns all keys below the source prefix.
+func (d *s3Downloader) list(src *s3Source) ([]string, error) {
+	var (
+		keys  []string
+		token string
+	)
+	for {
+		u := src.objectURL("")
+		query := url.Values{"list-type": {"2"}, "prefix": {src.key}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		u.RawQuery = s3CanonicalQuery(query)
+		req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := d.do(req, src)
+		if err != nil {
+			return nil, err
+		}
+		var result s3ListBucketResult
+		err = decodeXMLResponse(resp, &result)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't list s3://%s/%s: %w", src.bucket, src.key, err)
+		}
+		for _, c := range result.Contents {
+			keys = append(keys, c.Key)
+		}
+		if !result.IsTruncated {
+			return keys, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (d *s3Downloader) do(req *http.Request, src *s3Source) (*http.Response, error) {
+	if src.accessKey != "" {
+		if src.sessionToken != "" {
+			req.Header.Set("X-Amz-Security-Token", src.sessionToken)
+		}
+		signV4(req, src.accessKey, src.secretKey, src.region, "s3", d.now())
+	}
+	return d.HTTPClient.Do(req)
+}
+
+// signV4 signs a request without body using AWS Signature Version 4. All
+// headers set on the request are signed.
+func signV4(req *http.Request, accessKey, secretKey, region, service string, t time.Time) {
+	var (
+		amzDate = t.UTC().Format("20060102T150405Z")
+		date    = amzDate[:8]
+		scope   = date + "/" + region + "/" + service + "/aws4_request"
+	)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptySHA256)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		emptySHA256,
+	}, "\n")
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	signature := hex.EncodeToString(hmacSHA256(signingKeyV4(secretKey, date, region, service), stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKey, scope, signedHeaders, signature))
+}
+
+func signingKeyV4(secretKey, date, region, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	return hmacSHA256(key, "aws4_request")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func s3CanonicalQuery(values url.Values) string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		vs := append([]string(nil), values[k]...)
+		sort.Strings(vs)
+		for _, v := range vs {
+			parts = append(parts, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func s3EscapePath(path string) string {
+	return s3Escape(path, false)
+}
+
+// s3Escape percent encodes all characters except the unreserved ones as
+// required by AWS Signature Version 4. Slashes are only encoded if
+// encodeSlash is true.
+func s3Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,190 @@
+package initializer
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// newSFTPServer starts an in-process SFTP server serving the local filesystem
+// to user with password or clientKey and returns its address and host key.
+func newSFTPServer(t *testing.T, password string, clientKey ssh.PublicKey) (string, ssh.PublicKey) {
+	t.Helper()
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, p []byte) (*ssh.Permissions, error) {
+			if conn.User() == "user" && string(p) == password {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("invalid password")
+		},
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == "user" && string(key.Marshal()) == string(clientKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("invalid key")
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSFTP(conn, config)
+		}
+	}()
+	return l.Addr().String(), hostKey.PublicKey()
+}
+
+func serveSFTP(conn net.Conn, config *ssh.ServerConfig) {
+	defer conn.Close()
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range requests {
+				// The payload is the length prefixed subsystem name.
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if ok {
+					server, err := sftp.NewServer(channel)
+					if err != nil {
+						return
+					}
+					server.Serve()
+					channel.Close()
+				}
+			}
+		}()
+	}
+}
+
+func TestSFTPDownloader(t *testing.T) {
+	clientPub, clientPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientKey, err := ssh.NewPublicKey(clientPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(clientPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshKey := string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+
+	addr, hostKey := newSFTPServer(t, "secret", clientKey)
+	hostKeyLine := string(ssh.MarshalAuthorizedKey(hostKey))
+
+	remote := t.TempDir()
+	for name, content := range map[string]string{
+		"checkpoint.pt":      "weights",
+		"run/config.yaml":    "lr: 0.1",
+		"run/logs/train.log": "step 1",
+	} {
+		path := filepath.Join(remote, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	remotePath := filepath.ToSlash(remote)
+
+	for _, tc := range []struct {
+		name        string
+		source      string
+		expected    map[string]string
+		expectedErr string
+	}{
+		{
+			name:     "password and fingerprint",
+			source:   "sftp://user:secret@" + addr + remotePath + "/checkpoint.pt#host_key=" + url.QueryEscape(ssh.FingerprintSHA256(hostKey)),
+			expected: map[string]string{".": "weights"},
+		},
+		{
+			name:   "key and recursive",
+			source: "sftp://user@" + addr + remotePath + "/run#host_key=" + url.QueryEscape(hostKeyLine) + "&ssh_key=" + url.QueryEscape(sshKey),
+			expected: map[string]string{
+				"config.yaml":    "lr: 0.1",
+				"logs/train.log": "step 1",
+			},
+		},
+		{
+			name:        "wrong password",
+			source:      "sftp://user:wrong@" + addr + remotePath + "/checkpoint.pt#host_key=" + url.QueryEscape(hostKeyLine),
+			expectedErr: "couldn't connect to " + addr + ": ssh: handshake failed: ssh: unable to authenticate, attempted methods [none password], no supported methods remain",
+		},
+		{
+			name:        "host key mismatch",
+			source:      "sftp://user:secret@" + addr + remotePath + "/checkpoint.pt#host_key=" + url.QueryEscape(string(ssh.MarshalAuthorizedKey(clientKey))),
+			expectedErr: "couldn't connect to " + addr + ": ssh: handshake failed: host key mismatch for " + addr + ": got " + ssh.FingerprintSHA256(hostKey),
+		},
+		{
+			name:        "missing host key",
+			source:      "sftp://user:secret@" + addr + remotePath + "/checkpoint.pt",
+			expectedErr: "invalid url: sftp needs a host_key to verify the server",
+		},
+		{
+			name:        "missing file",
+			source:      "sftp://user:secret@" + addr + remotePath + "/missing#host_key=" + url.QueryEscape(hostKeyLine),
+			expectedErr: "couldn't list " + remotePath + "/missing: file does not exist",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dest := filepath.Join(t.TempDir(), "dest")
+			err := (&sftpDownloader{}).Download(dest, tc.source)
+			if tc.expectedErr != "" {
+				if err == nil || err.Error() != tc.expectedErr {
+					t.Fatalf("expected error %q, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.expected, readTree(t, dest)); diff != "" {
+				t.Errorf("files mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
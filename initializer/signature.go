@@ -0,0 +1,281 @@
+package initializer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// ErrInvalidSignature is returned when the signature of a download doesn't
+// verify with its key.
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// maxSignatureSize limits the size of signatures downloaded from urls.
+const maxSignatureSize = 64 << 10
+
+// maxUnhashedSize limits the size of files verified with signatures over
+// their whole content, like ed25519 and legacy minisign signatures, which
+// need the file in memory.
+const maxUnhashedSize = 256 << 20
+
+// signature is the detached signature of a source and the name of the key
+// to verify it with. sig is either an http(s) url or the signature itself.
+type signature struct {
+	sig string
+	key string
+}
+
+// publicKey verifies signatures of files, minisign keys verify minisign
+// signatures and PEM encoded keys base64 encoded signatures like cosign
+// creates with sign-blob.
+type publicKey interface {
+	verify(path string, sig []byte) error
+}
+
+// parsePublicKey parses a minisign public key, with or without its untrusted
+// comment line, or a PEM encoded ed25519, ECDSA or RSA public key.
+func parsePublicKey(s string) (publicKey, error) {
+	s = strings.TrimSpace(s)
+	if block, _ := pem.Decode([]byte(s)); block != nil {
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't parse public key: %w", err)
+		}
+		switch key := key.(type) {
+		case ed25519.PublicKey, *ecdsa.PublicKey, *rsa.PublicKey:
+			return &pkixKey{key: key}, nil
+		default:
+			return nil, fmt.Errorf("couldn't parse public key: unsupported key type %T", key)
+		}
+	}
+	b, err := base64.StdEncoding.DecodeString(lastLine(s))
+	if err != nil || len(b) != 42 || string(b[:2]) != "Ed" {
+		return nil, errors.New("couldn't parse public key: needs to be a minisign or PEM encoded key")
+	}
+	k := &minisignKey{key: ed25519.PublicKey(b[10:])}
+	copy(k.id[:], b[2:10])
+	return k, nil
+}
+
+// lastLine returns the last line of s, skipping comments like in minisign
+// key files.
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+type pkixKey struct {
+	key crypto.PublicKey
+}
+
+func (k *pkixKey) verify(path string, sig []byte) error {
+	sig, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(sig)))
+	if err != nil {
+		return fmt.Errorf("%w: needs to be base64 encoded", ErrInvalidSignature)
+	}
+	if key, ok := k.key.(ed25519.PublicKey); ok {
+		// ed25519 signs the message itself, not a digest.
+		content, err := readUnhashed(path)
+		if err != nil {
+			return err
+		}
+		if !ed25519.Verify(key, content, sig) {
+			return fmt.Errorf("%w for %s", ErrInvalidSignature, path)
+		}
+		return nil
+	}
+	digest, err := hashFile(path, sha256.New())
+	if err != nil {
+		return err
+	}
+	switch key := k.key.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, digest, sig) {
+			return fmt.Errorf("%w for %s", ErrInvalidSignature, path)
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest, sig); err != nil {
+			return fmt.Errorf("%w for %s", ErrInvalidSignature, path)
+		}
+	}
+	return nil
+}
+
+type minisignKey struct {
+	id  [8]byte
+	key ed25519.PublicKey
+}
+
+// verify verifies a minisign signature file, consisting of an untrusted
+// comment, the signature, a trusted comment and the signature of the
+// signature and trusted comment.
+func (k *minisignKey) verify(path string, sig []byte) error {
+	lines := strings.Split(strings.TrimSpace(strings.ReplaceAll(string(sig), "\r\n", "\n")), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		return fmt.Errorf("%w: needs to be a minisign signature", ErrInvalidSignature)
+	}
+	b, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(b) != 74 {
+		return fmt.Errorf("%w: needs to be a minisign signature", ErrInvalidSignature)
+	}
+	if !bytes.Equal(b[2:10], k.id[:]) {
+		return fmt.Errorf("%w: signed with key %X, not %X", ErrInvalidSignature, b[2:10], k.id)
+	}
+	global, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil || len(global) != ed25519.SignatureSize {
+		return fmt.Errorf("%w: needs to be a minisign signature", ErrInvalidSignature)
+	}
+	trusted := strings.TrimPrefix(lines[2], "trusted comment: ")
+	if !ed25519.Verify(k.key, append(append([]byte{}, b[10:]...), trusted...), global) {
+		return fmt.Errorf("%w: trusted comment doesn't verify", ErrInvalidSignature)
+	}
+
+	var message []byte
+	switch string(b[:2]) {
+	case "ED":
+		// Prehashed, the default since minisign 0.11.
+		h, _ := blake2b.New512(nil)
+		if message, err = hashFile(path, h); err != nil {
+			return err
+		}
+	case "Ed":
+		if message, err = readUnhashed(path); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidSignature, b[:2])
+	}
+	if !ed25519.Verify(k.key, message, b[10:]) {
+		return fmt.Errorf("%w for %s", ErrInvalidSignature, path)
+	}
+	return nil
+}
+
+// readUnhashed reads the file at path for signatures over its whole content,
+// rejecting files larger than maxUnhashedSize.
+func readUnhashed(path string) ([]byte, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() > maxUnhashedSize {
+		return nil, fmt.Errorf("couldn't verify signature of %s: files larger than %d bytes need a prehashed signature like minisign's default", path, maxUnhashedSize)
+	}
+	return os.ReadFile(path)
+}
+
+func hashFile(path string, h interface {
+	io.Writer
+	Sum([]byte) []byte
+}) ([]byte, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	if _, err := io.Copy(h, fh); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// cutSignature removes the signature and key options from the fragment of u
+// and returns them.
+func cutSignature(u *url.URL) (*signature, error) {
+	sig, err := cutFragment(u, "signature")
+	if err != nil {
+		return nil, err
+	}
+	key, err := cutFragment(u, "key")
+	if err != nil {
+		return nil, err
+	}
+	if sig == "" && key == "" {
+		return nil, nil
+	}
+	if sig == "" || key == "" {
+		return nil, errors.New("signature and key need to be set together")
+	}
+	return &signature{sig: sig, key: key}, nil
+}
+
+// publicKey returns the named key from the secrets or the content of the
+// inline asset with the name as path, like
+// {"keys/diambra.pub": "literal:untrusted comment: ..."}. Names in both are
+// rejected, so a secret can't silently replace a key pinned in the assets.
+func (i *Initializer) publicKey(name string) (string, error) {
+	key, inSecrets := i.secrets[name]
+	asset, inAssets := i.assets[name]
+	switch {
+	case inSecrets && inAssets:
+		return "", fmt.Errorf("couldn't verify signature: public key %s is ambiguous, it is in both secrets and assets", name)
+	case inSecrets:
+		return key, nil
+	case !inAssets:
+		return "", fmt.Errorf("couldn't verify signature: no public key %s", name)
+	}
+	if !strings.HasPrefix(asset, literalPrefix) && !strings.HasPrefix(asset, "data:") {
+		return "", fmt.Errorf("couldn't verify signature: public key %s needs to be a literal: or data: asset", name)
+	}
+	content, err := parseDataSource(asset)
+	if err != nil {
+		return "", fmt.Errorf("invalid public key %s: %w", name, err)
+	}
+	return string(content), nil
+}
+
+// verifySignature verifies the file at path with the signature using the
+// named key from the secrets or assets.
+func (i *Initializer) verifySignature(path string, s *signature) error {
+	keyStr, err := i.publicKey(s.key)
+	if err != nil {
+		return err
+	}
+	key, err := parsePublicKey(keyStr)
+	if err != nil {
+		return fmt.Errorf("invalid public key %s: %w", s.key, err)
+	}
+	sig := []byte(s.sig)
+	if u, err := url.Parse(s.sig); err == nil && (u.Scheme == "https" || u.Scheme == "http") {
+		if sig, err = i.fetchSignature(u); err != nil {
+			return err
+		}
+	}
+	if fi, err := os.Stat(path); err != nil || !fi.Mode().IsRegular() {
+		return fmt.Errorf("couldn't verify signature of %s: not a file", path)
+	}
+	return key.verify(path, sig)
+}
+
+func (i *Initializer) fetchSignature(u *url.URL) ([]byte, error) {
+	resp, err := i.client.Get(u.String())
+	if err != nil {
+		return nil, fmt.Errorf("couldn't download signature: %w", err)
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return nil, fmt.Errorf("couldn't download signature: %w", err)
+	}
+	sig, err := io.ReadAll(io.LimitReader(resp.Body, maxSignatureSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't download signature: %w", err)
+	}
+	if len(sig) > maxSignatureSize {
+		return nil, fmt.Errorf("couldn't download signature: exceeds %d bytes", maxSignatureSize)
+	}
+	return sig, nil
+}
@@ -0,0 +1,158 @@
+package initializer
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"golang.org/x/crypto/blake2b"
+)
+
+// minisign returns a minisign public key and a signer creating minisign
+// signatures with it, prehashed unless legacy is set.
+func minisign(t *testing.T) (string, func(content []byte, legacy bool) string) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := []byte("12345678")
+	key := "untrusted comment: minisign public key\n" + base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), id...), pub...))
+	return key, func(content []byte, legacy bool) string {
+		alg, message := "ED", blake2b.Sum512(content)
+		sig := ed25519.Sign(priv, message[:])
+		if legacy {
+			alg, sig = "Ed", ed25519.Sign(priv, content)
+		}
+		trusted := "timestamp:1234\tfile:model.bin"
+		global := ed25519.Sign(priv, append(append([]byte{}, sig...), trusted...))
+		return "untrusted comment: signature from minisign secret key\n" +
+			base64.StdEncoding.EncodeToString(append(append([]byte(alg), id...), sig...)) + "\n" +
+			"trusted comment: " + trusted + "\n" +
+			base64.StdEncoding.EncodeToString(global) + "\n"
+	}
+}
+
+func pemPublicKey(t *testing.T, key interface{}) string {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func TestSignature(t *testing.T) {
+	content := []byte("model weights")
+	minisignKey, minisignSign := minisign(t)
+
+	ecdsaPriv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(content)
+	ecdsaSig, err := ecdsa.SignASN1(rand.Reader, ecdsaPriv, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signatures := map[string]string{
+		"/model.bin.minisig": minisignSign(content, false),
+		"/model.bin.sig":     base64.StdEncoding.EncodeToString(ecdsaSig),
+		"/other.minisig":     minisignSign([]byte("other"), false),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if sig, ok := signatures[r.URL.Path]; ok {
+			w.Write([]byte(sig))
+			return
+		}
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	assets := map[string]string{
+		"minisign": "literal:" + minisignKey,
+		"cosign":   "data:," + url.PathEscape(pemPublicKey(t, &ecdsaPriv.PublicKey)),
+		"remote":   server.URL + "/key.pub",
+		"shadowed": "literal:" + minisignKey,
+	}
+	secrets := map[string]string{"ed25519": pemPublicKey(t, edPub), "shadowed": pemPublicKey(t, edPub)}
+
+	for _, tc := range []struct {
+		name        string
+		signature   string
+		key         string
+		expectedErr string
+	}{
+		{name: "minisign url", signature: server.URL + "/model.bin.minisig", key: "minisign"},
+		{name: "minisign inline", signature: minisignSign(content, false), key: "minisign"},
+		{name: "minisign legacy", signature: minisignSign(content, true), key: "minisign"},
+		{name: "cosign", signature: server.URL + "/model.bin.sig", key: "cosign"},
+		{name: "ed25519 from secrets", signature: base64.StdEncoding.EncodeToString(ed25519.Sign(edPriv, content)), key: "ed25519"},
+		{name: "wrong file", signature: server.URL + "/other.minisig", key: "minisign", expectedErr: "invalid signature for "},
+		{name: "wrong key", signature: server.URL + "/model.bin.sig", key: "ed25519", expectedErr: "invalid signature for "},
+		{name: "unknown key", signature: server.URL + "/model.bin.sig", key: "unknown", expectedErr: "no public key unknown"},
+		{name: "key in secrets and assets", signature: base64.StdEncoding.EncodeToString(ed25519.Sign(edPriv, content)), key: "shadowed", expectedErr: "public key shadowed is ambiguous"},
+		{name: "downloaded key", signature: server.URL + "/model.bin.sig", key: "remote", expectedErr: "public key remote needs to be a literal: or data: asset"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			source := server.URL + "/model.bin#signature=" + url.QueryEscape(tc.signature) + "&key=" + tc.key
+			init, err := NewInitializer(log.NewNopLogger(), map[string]string{"model": source}, secrets, assets, t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = init.Init()
+			if tc.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+					t.Fatalf("expected error containing %q, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	// A tampered trusted comment invalidates minisign signatures.
+	sig := strings.Replace(minisignSign(content, false), "file:model.bin", "file:other.bin", 1)
+	init, err := NewInitializer(log.NewNopLogger(), map[string]string{"model": server.URL + "/model.bin#signature=" + url.QueryEscape(sig) + "&key=minisign"}, nil, assets, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := init.Init(); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("expected invalid signature, got %v", err)
+	}
+
+	if err := (&Sources{"model": "https://example.com/model.bin#signature=abc"}).Validate(); err == nil {
+		t.Fatal("expected error for signature without key")
+	}
+
+	large := filepath.Join(t.TempDir(), "large")
+	if err := os.WriteFile(large, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(large, maxUnhashedSize+1); err != nil {
+		t.Fatal(err)
+	}
+	key, _ := parsePublicKey(pemPublicKey(t, edPub))
+	if err := key.verify(large, []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(edPriv, nil)))); err == nil || !strings.Contains(err.Error(), "need a prehashed signature") {
+		t.Fatalf("expected error for large file, got %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+package initializer
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var sizeUnits = map[string]int64{
+	"":  1,
+	"K": 1 << 10,
+	"M": 1 << 20,
+	"G": 1 << 30,
+	"T": 1 << 40,
+}
+
+// ParseSize parses a size in bytes with an optional K, M, G or T suffix for
+// the power of 1024, like 512M.
+func ParseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	num := strings.TrimRightFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+	unit, ok := sizeUnits[s[len(num):]]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %s: only K, M, G and T are supported as unit", s)
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %s: needs to be a positive number", s)
+	}
+	if n > (1<<63-1)/unit {
+		return 0, fmt.Errorf("invalid size %s: too large", s)
+	}
+	return n * unit, nil
+}
+
+// limitResponse rejects resp if its Content-Length exceeds max and makes
+// reading its body fail after max bytes otherwise.
+func limitResponse(resp *http.Response, max int64) error {
+	if resp.ContentLength > max {
+		resp.Body.Close()
+		return fmt.Errorf("response of %d bytes exceeds max size of %d bytes", resp.ContentLength, max)
+	}
+	resp.Body = &limitedBody{ReadCloser: resp.Body, remaining: max, max: max}
+	return nil
+}
+
+// limitedBody fails reads after max bytes, unlike io.LimitReader which
+// silently truncates.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	max       int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		// Only fail if there actually is more data.
+		var buf [1]byte
+		if n, _ := b.ReadCloser.Read(buf[:]); n > 0 {
+			return 0, fmt.Errorf("response exceeds max size of %d bytes", b.max)
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// limitTransport limits the size of all responses.
+type limitTransport struct {
+	transport http.RoundTripper
+	max       int64
+}
+
+func (t *limitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := limitResponse(resp, t.max); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,168 @@
+package initializer
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+)
+
+func TestParseSize(t *testing.T) {
+	for _, tc := range []struct {
+		size        string
+		expected    int64
+		expectedErr string
+	}{
+		{size: "1024", expected: 1024},
+		{size: "512M", expected: 512 << 20},
+		{size: "2g", expected: 2 << 30},
+		{size: "1T", expected: 1 << 40},
+		{size: "1P", expectedErr: "invalid size 1P: only K, M, G and T are supported as unit"},
+		{size: "-1", expectedErr: "invalid size -1: needs to be a positive number"},
+		{size: "9999999999T", expectedErr: "invalid size 9999999999T: too large"},
+	} {
+		t.Run(tc.size, func(t *testing.T) {
+			got, err := ParseSize(tc.size)
+			if tc.expectedErr != "" {
+				if err == nil || err.Error() != tc.expectedErr {
+					t.Fatalf("expected error %q, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.expected {
+				t.Errorf("expected %d, got %d", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestHTTPSizeLimits(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/small":
+			fmt.Fprint(w, strings.Repeat("x", 100))
+		case "/large":
+			w.Header().Set("Content-Length", "10000")
+			fmt.Fprint(w, strings.Repeat("x", 10000))
+		case "/stream":
+			// Without Content-Length the limit is only hit while reading.
+			for i := 0; i < 100; i++ {
+				fmt.Fprint(w, strings.Repeat("x", 1000))
+				w.(http.Flusher).Flush()
+			}
+		case "/truncated", "/huge":
+			length := "1000"
+			if r.URL.Path == "/huge" {
+				length = "1152921504606846976"
+			}
+			conn, buf, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				panic(err)
+			}
+			defer conn.Close()
+			fmt.Fprintf(buf, "HTTP/1.1 200 OK\r\nContent-Length: %s\r\n\r\n%s", length, strings.Repeat("x", 10))
+			buf.Flush()
+		}
+	}))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		name        string
+		source      string
+		options     []Option
+		expectedErr string
+	}{
+		{
+			name:   "below limits",
+			source: server.URL + "/small#max_size=1K",
+		},
+		{
+			name:        "source limit by content length",
+			source:      server.URL + "/large#max_size=1K",
+			expectedErr: "response of 10000 bytes exceeds max size of 1024 bytes",
+		},
+		{
+			name:        "global limit by content length",
+			source:      server.URL + "/large",
+			options:     []Option{WithMaxSize(1024)},
+			expectedErr: "response of 10000 bytes exceeds max size of 1024 bytes",
+		},
+		{
+			name:        "global limit on stream",
+			source:      server.URL + "/stream",
+			options:     []Option{WithMaxSize(10000)},
+			expectedErr: "response exceeds max size of 10000 bytes",
+		},
+		{
+			name:        "source limit on stream",
+			source:      server.URL + "/stream#max_size=10K",
+			expectedErr: "response exceeds max size of 10240 bytes",
+		},
+		{
+			name:        "truncated",
+			source:      server.URL + "/truncated",
+			expectedErr: "unexpected EOF",
+		},
+		{
+			name:        "not enough space",
+			source:      server.URL + "/huge",
+			expectedErr: "not enough space for ",
+		},
+		{
+			name:        "invalid max size",
+			source:      server.URL + "/small#max_size=1X",
+			expectedErr: "invalid size 1X: only K, M, G and T are supported as unit",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			init, err := NewInitializer(log.NewNopLogger(), map[string]string{"file": tc.source}, nil, nil, root, tc.options...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = init.Init()
+			if tc.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+					t.Fatalf("expected error containing %q, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi, err := os.Stat(filepath.Join(root, "file")); err != nil || fi.Size() != 100 {
+				t.Errorf("unexpected file: %v %v", fi, err)
+			}
+		})
+	}
+
+	for _, source := range []string{"s3://bucket/key#max_size=1K", "hf://org/model#max_size=1K", "sftp://host/file#max_size=1K"} {
+		if err := (&Sources{"file": source}).Validate(); err == nil || !strings.Contains(err.Error(), "max_size is only supported for http(s)") {
+			t.Errorf("%s: expected error for max_size, got %v", source, err)
+		}
+	}
+
+	// Existing files are truncated, not partially overwritten.
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "file"), make([]byte, 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	init, err := NewInitializer(log.NewNopLogger(), map[string]string{"file": server.URL + "/small"}, nil, nil, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := init.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(filepath.Join(root, "file")); err != nil || fi.Size() != 100 {
+		t.Errorf("expected existing file to be truncated: %v %v", fi, err)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package initializer
+
+// freeSpace isn't implemented on this platform, so the free space precheck
+// is skipped.
+func freeSpace(path string) (uint64, bool) {
+	return 0, false
+}
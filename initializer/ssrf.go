@@ -0,0 +1,162 @@
+package initializer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrBlockedAddress is returned when connecting to an address blocked by
+// the SSRF protection.
+var ErrBlockedAddress = errors.New("address is blocked")
+
+// blockedNetworks are the networks which aren't reachable with SSRF
+// protection enabled in addition to loopback, private and link-local
+// addresses.
+var blockedNetworks = mustParseNetworks(
+	"0.0.0.0/8",         // "this" network
+	"100.64.0.0/10",     // carrier grade NAT, used for metadata by some clouds
+	"192.0.0.0/24",      // IETF protocol assignments
+	"198.18.0.0/15",     // benchmarking
+	"fd00:ec2::254/128", // EC2 metadata over IPv6
+	"64:ff9b::/96",      // NAT64, may embed any of the above
+)
+
+// addressPolicy rejects connections to loopback, private, link-local and
+// metadata addresses unless they are in one of the allowed networks. It is
+// checked after DNS resolution, so it also covers redirects and names
+// resolving to internal addresses.
+type addressPolicy struct {
+	allowed []*net.IPNet
+}
+
+// ParseNetworks parses comma separated networks in CIDR notation or single
+// IP addresses.
+func ParseNetworks(s string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, n := range strings.Split(s, ",") {
+		n = strings.TrimSpace(n)
+		if n == "" {
+			continue
+		}
+		if ip := net.ParseIP(n); ip != nil {
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(n)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %s: needs to be an IP address or CIDR", n)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func mustParseNetworks(networks ...string) []*net.IPNet {
+	parsed, err := ParseNetworks(strings.Join(networks, ","))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}
+
+func (p *addressPolicy) check(ip net.IP) error {
+	for _, n := range p.allowed {
+		if n.Contains(ip) {
+			return nil
+		}
+	}
+	blocked := ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast()
+	for _, n := range blockedNetworks {
+		blocked = blocked || n.Contains(ip)
+	}
+	if blocked {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, ip)
+	}
+	return nil
+}
+
+// control is used as net.Dialer.Control to check the resolved address of
+// each connection.
+func (p *addressPolicy) control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("%w: %s isn't an IP address", ErrBlockedAddress, host)
+	}
+	return p.check(ip)
+}
+
+func (p *addressPolicy) dialer() *net.Dialer {
+	return &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   p.control,
+	}
+}
+
+// resolve returns an allowed address of host for tools which can't use the
+// dialer, like git. All addresses of host need to be allowed.
+func (p *addressPolicy) resolve(host string) (net.IP, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(context.Background(), host)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't resolve %s: %w", host, err)
+	}
+	for _, addr := range addrs {
+		if err := p.check(addr.IP); err != nil {
+			return nil, fmt.Errorf("%s resolves to blocked address: %w", host, err)
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("couldn't resolve %s: no addresses", host)
+	}
+	return addrs[0].IP, nil
+}
+
+// proxy wraps the proxy func of a transport to check the target of proxied
+// requests. The dialer only sees the address of the proxy, so the target is
+// resolved and checked before handing the request to the proxy.
+func (p *addressPolicy) proxy(next func(*http.Request) (*url.URL, error)) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		u, err := next(req)
+		if err != nil || u == nil {
+			return u, err
+		}
+		if _, err := p.resolve(req.URL.Hostname()); err != nil {
+			return nil, err
+		}
+		return u, nil
+	}
+}
+
+// hostKeyCallback wraps an ssh host key callback to check the address the
+// client actually connected to, so a host resolving to another address after
+// the check up front is rejected before authenticating.
+func (p *addressPolicy) hostKeyCallback(next ssh.HostKeyCallback) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		addr, ok := remote.(*net.TCPAddr)
+		if !ok {
+			return fmt.Errorf("%w: %s isn't a tcp address", ErrBlockedAddress, remote)
+		}
+		if err := p.check(addr.IP); err != nil {
+			return err
+		}
+		return next(hostname, remote, key)
+	}
+}
@@ -0,0 +1,154 @@
+package initializer
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestAddressPolicy(t *testing.T) {
+	allowed, err := ParseNetworks("10.1.0.0/16, 192.168.1.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := &addressPolicy{allowed: allowed}
+	for ip, blocked := range map[string]bool{
+		"8.8.8.8":          false,
+		"2001:4860::8888":  false,
+		"127.0.0.1":        true,
+		"::1":              true,
+		"::ffff:127.0.0.1": true,
+		"10.2.3.4":         true,
+		"172.16.0.1":       true,
+		"192.168.1.6":      true,
+		"169.254.169.254":  true,
+		"fe80::1":          true,
+		"fd00:ec2::254":    true,
+		"100.100.100.200":  true,
+		"0.0.0.0":          true,
+		"10.1.2.3":         false,
+		"192.168.1.5":      false,
+	} {
+		err := policy.check(net.ParseIP(ip))
+		if blocked != errors.Is(err, ErrBlockedAddress) {
+			t.Errorf("%s: expected blocked=%v, got %v", ip, blocked, err)
+		}
+	}
+
+	if _, err := ParseNetworks("10.0.0.0/33"); err == nil {
+		t.Error("expected error for invalid network")
+	}
+}
+
+func TestSSRFProtection(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/metadata" {
+			http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	loopback, _ := ParseNetworks("127.0.0.1")
+
+	for _, tc := range []struct {
+		name        string
+		source      string
+		allowed     []*net.IPNet
+		expectedErr string
+	}{
+		{
+			name:        "loopback",
+			source:      server.URL + "/file",
+			expectedErr: "address is blocked: 127.0.0.1",
+		},
+		{
+			name:        "name resolving to loopback",
+			source:      strings.Replace(server.URL, "127.0.0.1", "localhost", 1) + "/file",
+			expectedErr: "address is blocked: ",
+		},
+		{
+			name:    "allowed",
+			source:  server.URL + "/file",
+			allowed: loopback,
+		},
+		{
+			name:        "redirect to metadata",
+			source:      server.URL + "/metadata",
+			allowed:     loopback,
+			expectedErr: "address is blocked: 169.254.169.254",
+		},
+		{
+			name:        "git",
+			source:      "git+https://localhost/repo.git",
+			expectedErr: "localhost resolves to blocked address: address is blocked: ",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			init, err := NewInitializer(log.NewNopLogger(), map[string]string{"file": tc.source}, nil, nil, t.TempDir(), WithSSRFProtection(tc.allowed))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = init.Init()
+			if tc.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedErr) || !errors.Is(err, ErrBlockedAddress) {
+					t.Fatalf("expected error containing %q, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestSSRFProtectionProxy(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("proxied"))
+	}))
+	defer proxy.Close()
+	loopback, _ := ParseNetworks("127.0.0.1")
+
+	// The proxy is allowed, the target it would connect to isn't.
+	init, err := NewInitializer(log.NewNopLogger(), map[string]string{"file": "http://169.254.169.254/latest/meta-data/"}, nil, nil, t.TempDir(),
+		WithProxy(ProxyConfig{HTTPProxy: proxy.URL}), WithSSRFProtection(loopback))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := init.Init(); !errors.Is(err, ErrBlockedAddress) {
+		t.Fatalf("expected blocked address, got %v", err)
+	}
+}
+
+func TestWithoutProxyEnv(t *testing.T) {
+	env := withoutProxyEnv([]string{"HOME=/root", "HTTPS_PROXY=http://proxy", "http_proxy=http://proxy", "ALL_PROXY=socks5://proxy", "NO_PROXY=.internal", "PROXY=keep"})
+	if diff := cmp.Diff([]string{"HOME=/root", "PROXY=keep"}, env); diff != "" {
+		t.Fatalf("unexpected env (-want +got):\n%s", diff)
+	}
+}
+
+func TestSSRFHostKeyCallback(t *testing.T) {
+	policy := &addressPolicy{}
+	called := false
+	callback := policy.hostKeyCallback(func(string, net.Addr, ssh.PublicKey) error {
+		called = true
+		return nil
+	})
+	if err := callback("git.example.com:22", &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 22}, nil); !errors.Is(err, ErrBlockedAddress) {
+		t.Fatalf("expected blocked address, got %v", err)
+	}
+	if called {
+		t.Fatal("expected host key not to be checked for blocked address")
+	}
+	if err := callback("git.example.com:22", &net.TCPAddr{IP: net.ParseIP("8.8.8.8"), Port: 22}, nil); err != nil || !called {
+		t.Fatalf("expected host key to be checked, got %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+package initializer
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// extractTar extracts a, optionally gzip compressed, tar stream to dest.
+// Entries and link targets must not point outside of dest.
+func extractTar(r io.Reader, dest string) error {
+	return walkTar(r, func(tr *tar.Reader, hdr *tar.Header) error {
+		name := filepath.FromSlash(strings.TrimPrefix(hdr.Name, "./"))
+		if name == "" || name == "." {
+			return nil
+		}
+		return extractTarEntry(tr, hdr, name, dest)
+	})
+}
+
+// walkTar calls fn for each entry of a, optionally gzip compressed, tar
+// stream.
+func walkTar(r io.Reader, fn func(*tar.Reader, *tar.Header) error) error {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(len(gzipMagic)); err == nil && bytes.Equal(magic, gzipMagic) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("couldn't open gzip stream: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = br
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("couldn't read tar stream: %w", err)
+		}
+		if err := fn(tr, hdr); err != nil {
+			return err
+		}
+	}
+}
+
+// extractTarEntry extracts the entry to name in dest. Symlinks to targets
+// outside of dest are rejected and never followed when writing entries.
+func extractTarEntry(tr *tar.Reader, hdr *tar.Header, name, dest string) error {
+	if !filepath.IsLocal(name) {
+		return fmt.Errorf("invalid path %s in archive: needs to be a relative path", hdr.Name)
+	}
+	if err := checkNoSymlinkParents(dest, name); err != nil {
+		return err
+	}
+	path := filepath.Join(dest, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("couldn't create directory %s: %w", filepath.Dir(path), err)
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return fmt.Errorf("couldn't create directory %s: %w", path, err)
+		}
+	case tar.TypeReg:
+		os.Remove(path)
+		fh, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, hdr.FileInfo().Mode().Perm())
+		if err != nil {
+			return fmt.Errorf("couldn't create file %s: %w", path, err)
+		}
+		defer fh.Close()
+		if _, err := io.Copy(fh, tr); err != nil {
+			return fmt.Errorf("couldn't extract file %s: %w", path, err)
+		}
+	case tar.TypeSymlink:
+		// The target is cleaned, so .. is only at its start and can't
+		// follow another symlink like in b/.. with b -> .., which would
+		// escape dest although the text looks local.
+		target := filepath.Clean(filepath.FromSlash(hdr.Linkname))
+		if filepath.IsAbs(target) || !filepath.IsLocal(filepath.Join(filepath.Dir(name), target)) {
+			return fmt.Errorf("invalid symlink %s in archive: target %s is outside of destination", hdr.Name, hdr.Linkname)
+		}
+		os.Remove(path)
+		if err := os.Symlink(target, path); err != nil {
+			return fmt.Errorf("couldn't create symlink %s: %w", path, err)
+		}
+	case tar.TypeLink:
+		target := filepath.FromSlash(strings.TrimPrefix(hdr.Linkname, "./"))
+		if !filepath.IsLocal(target) {
+			return fmt.Errorf("invalid hardlink %s in archive: target %s is outside of destination", hdr.Name, hdr.Linkname)
+		}
+		if err := checkNoSymlinkParents(dest, target); err != nil {
+			return err
+		}
+		// Hardlinks to symlinks would keep their relative target in
+		// another directory.
+		if fi, err := os.Lstat(filepath.Join(dest, target)); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("invalid hardlink %s in archive: target %s is a symlink", hdr.Name, hdr.Linkname)
+		}
+		os.Remove(path)
+		if err := os.Link(filepath.Join(dest, target), path); err != nil {
+			return fmt.Errorf("couldn't create hardlink %s: %w", path, err)
+		}
+	default:
+		// Devices, fifos etc. are not needed for sources.
+	}
+	return nil
+}
+
+// checkNoSymlinkParents makes sure none of the parent directories of name in
+// dest is a symlink, so writing name can't escape dest.
+func checkNoSymlinkParents(dest, name string) error {
+	dir := filepath.Dir(name)
+	for dir != "." {
+		fi, err := os.Lstat(filepath.Join(dest, dir))
+		if err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("invalid path %s in archive: parent %s is a symlink", name, dir)
+		}
+		dir = filepath.Dir(dir)
+	}
+	return nil
+}
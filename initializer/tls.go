@@ -0,0 +1,233 @@
+package initializer
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// defaultTLSHost is the key of the TLS config used for hosts without their
+// own config.
+const defaultTLSHost = "*"
+
+// systemCertFiles are the usual locations of the system CA bundle. git
+// replaces its CAs with the configured bundle, so the extra CAs are appended
+// to the first one found.
+var systemCertFiles = []string{
+	"/etc/ssl/certs/ca-certificates.crt",
+	"/etc/pki/tls/certs/ca-bundle.crt",
+	"/etc/ssl/ca-bundle.pem",
+	"/etc/ssl/cert.pem",
+}
+
+// TLSConfig configures TLS for a host. CA and the bundle in CAFile are
+// trusted in addition to the system CAs. Cert and Key are a PEM encoded
+// client certificate. All values are templated with the secrets.
+type TLSConfig struct {
+	CA     string `json:"ca"`
+	CAFile string `json:"ca_file"`
+	Cert   string `json:"cert"`
+	Key    string `json:"key"`
+}
+
+// ProxyConfig configures proxies like the HTTP_PROXY, HTTPS_PROXY and
+// NO_PROXY environment variables do.
+type ProxyConfig struct {
+	HTTPProxy  string
+	HTTPSProxy string
+	NoProxy    string
+}
+
+// ProxyFromEnvironment returns the proxy config from the environment,
+// including the lowercase variants of the variables.
+func ProxyFromEnvironment() ProxyConfig {
+	c := httpproxy.FromEnvironment()
+	return ProxyConfig{HTTPProxy: c.HTTPProxy, HTTPSProxy: c.HTTPSProxy, NoProxy: c.NoProxy}
+}
+
+func (c ProxyConfig) proxyFunc() func(*http.Request) (*url.URL, error) {
+	proxy := (&httpproxy.Config{HTTPProxy: c.HTTPProxy, HTTPSProxy: c.HTTPSProxy, NoProxy: c.NoProxy}).ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxy(req.URL)
+	}
+}
+
+// proxyEnv are the environment variables git and curl read proxies from.
+var proxyEnv = []string{"http_proxy", "https_proxy", "all_proxy", "no_proxy"}
+
+// withoutProxyEnv returns env without any proxy variables.
+func withoutProxyEnv(env []string) []string {
+	var filtered []string
+	for _, e := range env {
+		name := strings.ToLower(strings.SplitN(e, "=", 2)[0])
+		isProxy := false
+		for _, p := range proxyEnv {
+			isProxy = isProxy || name == p
+		}
+		if !isProxy {
+			filtered = append(filtered, e)
+		}
+	}
+	return filtered
+}
+
+// env returns the environment variables configuring the proxy for git.
+func (c ProxyConfig) env() []string {
+	return []string{
+		"http_proxy=" + c.HTTPProxy, "HTTP_PROXY=" + c.HTTPProxy,
+		"https_proxy=" + c.HTTPSProxy, "HTTPS_PROXY=" + c.HTTPSProxy,
+		"no_proxy=" + c.NoProxy, "NO_PROXY=" + c.NoProxy,
+	}
+}
+
+// render returns the config with all values templated with the secrets.
+func (c TLSConfig) render(secrets map[string]string) (TLSConfig, error) {
+	var err error
+	for _, v := range []*string{&c.CA, &c.CAFile, &c.Cert, &c.Key} {
+		if *v, err = renderTemplate(*v, secrets); err != nil {
+			return c, err
+		}
+	}
+	if (c.Cert == "") != (c.Key == "") {
+		return c, errors.New("cert and key need to be set together")
+	}
+	return c, nil
+}
+
+// caBundle returns the extra CAs as PEM.
+func (c TLSConfig) caBundle() ([]byte, error) {
+	var bundle []byte
+	if c.CAFile != "" {
+		b, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read ca_file: %w", err)
+		}
+		bundle = append(bundle, b...)
+		bundle = append(bundle, '\n')
+	}
+	return append(bundle, c.CA...), nil
+}
+
+func (c TLSConfig) clientConfig() (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	bundle, err := c.caBundle()
+	if err != nil {
+		return nil, err
+	}
+	if len(bundle) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, errors.New("couldn't parse any certificate from ca and ca_file")
+		}
+		config.RootCAs = pool
+	}
+	if c.Cert != "" {
+		cert, err := tls.X509KeyPair([]byte(c.Cert), []byte(c.Key))
+		if err != nil {
+			return nil, fmt.Errorf("couldn't load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// gitArgs writes the CA bundle and client certificate to dir and returns the
+// git config options using them for host, or all hosts if host is
+// defaultTLSHost.
+func (c TLSConfig) gitArgs(dir, host string) ([]string, error) {
+	prefix := "http.https://" + host + "/."
+	if host == defaultTLSHost {
+		prefix = "http."
+	}
+	name := fmt.Sprintf("%x", host)
+	var args []string
+	bundle, err := c.caBundle()
+	if err != nil {
+		return nil, err
+	}
+	if len(bundle) > 0 {
+		for _, f := range systemCertFiles {
+			if system, err := os.ReadFile(f); err == nil {
+				bundle = append(append(system, '\n'), bundle...)
+				break
+			}
+		}
+		caFile := filepath.Join(dir, name+"-ca.pem")
+		if err := writeSecretFile(caFile, string(bundle)); err != nil {
+			return nil, fmt.Errorf("couldn't write ca bundle: %w", err)
+		}
+		args = append(args, "-c", prefix+"sslCAInfo="+caFile)
+	}
+	if c.Cert != "" {
+		certFile, keyFile := filepath.Join(dir, name+"-cert.pem"), filepath.Join(dir, name+"-key.pem")
+		if err := writeSecretFile(certFile, c.Cert); err != nil {
+			return nil, fmt.Errorf("couldn't write client certificate: %w", err)
+		}
+		if err := writeSecretFile(keyFile, c.Key); err != nil {
+			return nil, fmt.Errorf("couldn't write client key: %w", err)
+		}
+		args = append(args, "-c", prefix+"sslCert="+certFile, "-c", prefix+"sslKey="+keyFile)
+	}
+	return args, nil
+}
+
+// tlsHostTransport uses a transport with the TLS config for the host of
+// the request, falling back to the default transport.
+type tlsHostTransport struct {
+	transport  http.RoundTripper
+	transports map[string]http.RoundTripper
+}
+
+func (t *tlsHostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if transport, ok := t.transports[req.URL.Host]; ok {
+		return transport.RoundTrip(req)
+	}
+	if transport, ok := t.transports[req.URL.Hostname()]; ok {
+		return transport.RoundTrip(req)
+	}
+	return t.transport.RoundTrip(req)
+}
+
+// newTransport returns a transport with the proxy, TLS configs and address
+// policy.
+func newTransport(proxy *ProxyConfig, configs map[string]TLSConfig, policy *addressPolicy) (http.RoundTripper, error) {
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	if proxy != nil {
+		base.Proxy = proxy.proxyFunc()
+	}
+	if policy != nil {
+		base.DialContext = policy.dialer().DialContext
+		if base.Proxy != nil {
+			base.Proxy = policy.proxy(base.Proxy)
+		}
+	}
+	transports := make(map[string]http.RoundTripper)
+	for host, c := range configs {
+		tlsConfig, err := c.clientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("invalid tls config for %s: %w", host, err)
+		}
+		if host == defaultTLSHost {
+			base.TLSClientConfig = tlsConfig
+			continue
+		}
+		t := base.Clone()
+		t.TLSClientConfig = tlsConfig
+		transports[host] = t
+	}
+	if len(transports) == 0 {
+		return base, nil
+	}
+	return &tlsHostTransport{transport: base, transports: transports}, nil
+}
@@ -0,0 +1,242 @@
+package initializer
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/google/go-cmp/cmp"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM string
+	keyPEM  string
+}
+
+// newTestCert returns a certificate signed by parent, or a self signed CA if
+// parent is nil.
+func newTestCert(t *testing.T, parent *testCert, name string) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		keyPEM:  string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	var (
+		ca     = newTestCert(t, nil, "internal ca")
+		server = newTestCert(t, ca, "artifacts")
+		client = newTestCert(t, ca, "init")
+	)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, []byte(ca.certPEM), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "hello %s", r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	serverCert, err := tls.X509KeyPair([]byte(server.certPEM), []byte(server.keyPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}
+	ts.StartTLS()
+	defer ts.Close()
+	host := strings.TrimPrefix(ts.URL, "https://")
+
+	secrets := map[string]string{"ca": ca.certPEM, "cert": client.certPEM, "key": client.keyPEM}
+	for _, tc := range []struct {
+		name        string
+		configs     map[string]TLSConfig
+		expectedErr string
+	}{
+		{
+			name:    "host config from secrets",
+			configs: map[string]TLSConfig{host: {CA: "{{ .Secrets.ca }}", Cert: "{{ .Secrets.cert }}", Key: "{{ .Secrets.key }}"}},
+		},
+		{
+			name:    "default config with ca file",
+			configs: map[string]TLSConfig{"*": {CAFile: caFile, Cert: "{{ .Secrets.cert }}", Key: "{{ .Secrets.key }}"}},
+		},
+		{
+			name:        "config for other host",
+			configs:     map[string]TLSConfig{"example.com": {CA: "{{ .Secrets.ca }}", Cert: "{{ .Secrets.cert }}", Key: "{{ .Secrets.key }}"}},
+			expectedErr: "certificate signed by unknown authority",
+		},
+		{
+			name:        "missing client certificate",
+			configs:     map[string]TLSConfig{host: {CA: "{{ .Secrets.ca }}"}},
+			expectedErr: "certificate required",
+		},
+		{
+			name:        "cert without key",
+			configs:     map[string]TLSConfig{host: {Cert: "{{ .Secrets.cert }}"}},
+			expectedErr: "invalid tls config for " + host + ": cert and key need to be set together",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			init, err := NewInitializer(log.NewNopLogger(), map[string]string{"file": ts.URL}, secrets, nil, root, WithTLSConfig(tc.configs))
+			if err == nil {
+				err = init.Init()
+			}
+			if tc.expectedErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+					t.Fatalf("expected error containing %q, got %v", tc.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, err := os.ReadFile(filepath.Join(root, "file"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != "hello init" {
+				t.Errorf("unexpected content %q", content)
+			}
+		})
+	}
+}
+
+func TestProxyConfig(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "proxied %s", r.URL)
+	}))
+	defer proxy.Close()
+
+	for _, tc := range []struct {
+		name     string
+		proxy    ProxyConfig
+		expected string
+	}{
+		{
+			name:     "proxy",
+			proxy:    ProxyConfig{HTTPProxy: proxy.URL},
+			expected: "proxied http://artifacts.internal/model.bin",
+		},
+		{
+			name:     "no proxy",
+			proxy:    ProxyConfig{HTTPProxy: proxy.URL, NoProxy: ".internal"},
+			expected: "",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			init, err := NewInitializer(log.NewNopLogger(), map[string]string{"model.bin": "http://artifacts.internal/model.bin"}, nil, nil, root, WithProxy(tc.proxy))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = init.Init()
+			if tc.expected == "" {
+				// Without the proxy the host can't be resolved.
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, err := os.ReadFile(filepath.Join(root, "model.bin"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != tc.expected {
+				t.Errorf("unexpected content %q", content)
+			}
+		})
+	}
+}
+
+func TestGitTLSArgs(t *testing.T) {
+	dir := t.TempDir()
+	g := &gitDownloader{tls: map[string]TLSConfig{
+		"git.internal": {Cert: "cert", Key: "key"},
+		"*":            {CA: "ca"},
+	}}
+	args, err := g.tlsArgs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		caFile   = filepath.Join(dir, "2a-ca.pem")
+		certFile = filepath.Join(dir, "6769742e696e7465726e616c-cert.pem")
+		keyFile  = filepath.Join(dir, "6769742e696e7465726e616c-key.pem")
+	)
+	expected := []string{
+		"-c", "http.sslCAInfo=" + caFile,
+		"-c", "http.https://git.internal/.sslCert=" + certFile,
+		"-c", "http.https://git.internal/.sslKey=" + keyFile,
+	}
+	if diff := cmp.Diff(expected, args); diff != "" {
+		t.Errorf("args mismatch (-want +got):\n%s", diff)
+	}
+	ca, err := os.ReadFile(caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(ca), "ca\n") {
+		t.Errorf("ca bundle doesn't end with extra ca: %q", ca[len(ca)-10:])
+	}
+}
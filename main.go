@@ -17,7 +17,12 @@
 package main
 
 import (
+	"encoding/json"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
 
 	"github.com/diambra/init/initializer"
 	"github.com/go-kit/log"
@@ -26,7 +31,7 @@ import (
 
 func main() {
 	var (
-		logger = log.With(log.NewLogfmtLogger(os.Stderr), "caller", log.Caller(3))
+		logger = log.With(log.NewLogfmtLogger(os.Stderr), "caller", log.Valuer(caller))
 
 		sources = os.Getenv("SOURCES")
 		root    = os.Getenv("ROOT")
@@ -39,7 +44,86 @@ func main() {
 		os.Exit(0)
 	}
 
-	init, err := initializer.NewInitializerFromStrings(logger, sources, os.Getenv("SECRETS"), os.Getenv("ASSETS"), root)
+	var options []initializer.Option
+	switch gitBackend := os.Getenv("GIT_BACKEND"); gitBackend {
+	case "", "cli":
+	case "native":
+		options = append(options, initializer.WithNativeGit())
+	default:
+		level.Error(logger).Log("msg", "invalid GIT_BACKEND, only cli and native are supported", "backend", gitBackend)
+		os.Exit(1)
+	}
+
+	if prefixes := os.Getenv("FILE_PREFIXES"); prefixes != "" {
+		options = append(options, initializer.WithFilePrefixes(filepath.SplitList(prefixes)...))
+	}
+
+	if headers := os.Getenv("HTTP_HEADERS"); headers != "" {
+		var hostHeaders map[string]map[string]string
+		if err := json.Unmarshal([]byte(headers), &hostHeaders); err != nil {
+			level.Error(logger).Log("msg", "invalid HTTP_HEADERS", "err", err)
+			os.Exit(1)
+		}
+		options = append(options, initializer.WithHostHeaders(hostHeaders))
+	}
+
+	if tlsConfig := os.Getenv("TLS_CONFIG"); tlsConfig != "" {
+		var configs map[string]initializer.TLSConfig
+		if err := json.Unmarshal([]byte(tlsConfig), &configs); err != nil {
+			level.Error(logger).Log("msg", "invalid TLS_CONFIG", "err", err)
+			os.Exit(1)
+		}
+		options = append(options, initializer.WithTLSConfig(configs))
+	}
+	options = append(options, initializer.WithProxy(initializer.ProxyFromEnvironment()))
+	if maxSize := os.Getenv("MAX_SIZE"); maxSize != "" {
+		max, err := initializer.ParseSize(maxSize)
+		if err != nil {
+			level.Error(logger).Log("msg", "invalid MAX_SIZE", "err", err)
+			os.Exit(1)
+		}
+		options = append(options, initializer.WithMaxSize(max))
+	}
+
+	if os.Getenv("SSRF_PROTECTION") == "true" {
+		allowed, err := initializer.ParseNetworks(os.Getenv("SSRF_ALLOW"))
+		if err != nil {
+			level.Error(logger).Log("msg", "invalid SSRF_ALLOW", "err", err)
+			os.Exit(1)
+		}
+		options = append(options, initializer.WithSSRFProtection(allowed))
+	}
+
+	redirects := initializer.DefaultRedirectPolicy
+	if maxHops := os.Getenv("REDIRECT_MAX_HOPS"); maxHops != "" {
+		n, err := strconv.Atoi(maxHops)
+		if err != nil || n < 0 {
+			level.Error(logger).Log("msg", "invalid REDIRECT_MAX_HOPS", "err", "needs to be a positive number")
+			os.Exit(1)
+		}
+		redirects.MaxHops = n
+	}
+	redirects.SameHost = os.Getenv("REDIRECT_SAME_HOST") == "true"
+	redirects.HTTPSOnly = os.Getenv("REDIRECT_HTTPS_ONLY") == "true"
+	options = append(options, initializer.WithRedirectPolicy(redirects))
+
+	if policy := os.Getenv("HOST_POLICY"); policy != "" {
+		p, err := initializer.ParseHostPolicy([]byte(policy))
+		if err != nil {
+			level.Error(logger).Log("msg", "invalid HOST_POLICY", "err", err)
+			os.Exit(1)
+		}
+		options = append(options, initializer.WithHostPolicy(p))
+	} else if file := os.Getenv("HOST_POLICY_FILE"); file != "" {
+		p, err := initializer.ReadHostPolicy(file)
+		if err != nil {
+			level.Error(logger).Log("msg", "invalid HOST_POLICY_FILE", "err", err)
+			os.Exit(1)
+		}
+		options = append(options, initializer.WithHostPolicy(p))
+	}
+
+	init, err := initializer.NewInitializerFromStrings(logger, sources, os.Getenv("SECRETS"), os.Getenv("ASSETS"), root, options...)
 	if err != nil {
 		level.Error(logger).Log("msg", err.Error())
 		os.Exit(1)
@@ -49,3 +133,20 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// caller returns the file and line of the first frame outside of go-kit/log
+// and the redacting logger of the initializer, so it doesn't depend on how
+// often the logger is wrapped.
+func caller() interface{} {
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, "github.com/go-kit/log") && !strings.Contains(frame.Function, "(*redactingLogger)") {
+			return filepath.Base(frame.File) + ":" + strconv.Itoa(frame.Line)
+		}
+		if !more {
+			return ""
+		}
+	}
+}